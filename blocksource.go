@@ -0,0 +1,178 @@
+package rdiff
+
+import (
+	"fmt"
+	"io"
+)
+
+// Range is a byte range of the original file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// BlockSource provides the ranges of the original file that copy commands read, for example from a chunk store.
+type BlockSource interface {
+	// ReadRanges returns the data of every range, in the order of ranges. The data of a range that goes past the
+	// end of the original file stop at the end of the file.
+	ReadRanges(ranges []Range) ([][]byte, error)
+}
+
+// readerAtChunkSize is the size of the reads of readerAtSource. Range data grow with what the original file holds, not
+// with the length a delta asks for.
+const readerAtChunkSize = 64 << 10
+
+type readerAtSource struct {
+	originalFile io.ReaderAt
+}
+
+// NewReaderAtSource returns a BlockSource reading the ranges from originalFile.
+func NewReaderAtSource(originalFile io.ReaderAt) BlockSource {
+	return &readerAtSource{originalFile: originalFile}
+}
+
+func (s *readerAtSource) ReadRanges(ranges []Range) ([][]byte, error) {
+	data := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		for length := int64(0); length < r.Length; {
+			chunkSize := r.Length - length
+			if chunkSize > readerAtChunkSize {
+				chunkSize = readerAtChunkSize
+			}
+
+			chunk := make([]byte, chunkSize)
+			n, err := s.originalFile.ReadAt(chunk, r.Offset+length)
+			data[i] = append(data[i], chunk[:n]...)
+			length += int64(n)
+			if err == io.EOF {
+				break
+			} else if n < len(chunk) {
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// blockSource requests the range of a copy command together with the ranges of the upcoming copy commands that
+// are not cached yet, as long as they fit in the cache.
+type blockSource struct {
+	source BlockSource
+	cache  spanCache
+}
+
+func (s *blockSource) copyTo(out io.Writer, position, length int64, upcoming []*patchCommand) error {
+	if span := s.cache.find(position, length); span != nil {
+		return span.write(out, position, length)
+	}
+
+	// A range larger than the cache is requested in pieces of the cache size, without the upcoming ranges.
+	for length > s.cache.size {
+		if err := s.copyTo(out, position, s.cache.size, nil); err != nil {
+			return err
+		}
+		position += s.cache.size
+		length -= s.cache.size
+	}
+
+	ranges := []Range{{Offset: position, Length: length}}
+	size := length
+	for _, command := range upcoming {
+		r := Range{Offset: int64(command.Position), Length: int64(command.Length)}
+		if command.Type != Copy || size+r.Length > s.cache.size || s.cache.find(r.Offset, r.Length) != nil {
+			continue
+		}
+
+		ranges = append(ranges, r)
+		size += r.Length
+	}
+
+	data, err := s.source.ReadRanges(ranges)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(ranges) {
+		return fmt.Errorf("block source returned %d ranges, expected %d", len(data), len(ranges))
+	}
+	for i, r := range ranges {
+		if int64(len(data[i])) > r.Length {
+			return fmt.Errorf("block source returned %d bytes at offset %d, expected %d", len(data[i]), r.Offset, r.Length)
+		}
+	}
+	if int64(len(data[0])) < length {
+		return io.ErrUnexpectedEOF
+	}
+
+	// Upcoming ranges past the end of the original file are not cached, the copies reading them fail later.
+	if length <= s.cache.size {
+		for i, r := range ranges {
+			if int64(len(data[i])) == r.Length {
+				s.cache.add(&cacheSpan{position: r.Offset, data: data[i]})
+			}
+		}
+	}
+
+	_, err = out.Write(data[0])
+	return err
+}
+
+func (s *blockSource) readBlock(position, length int64) ([]byte, error) {
+	if span := s.cache.find(position, length); span != nil {
+		return span.data[position-span.position : position-span.position+length], nil
+	}
+
+	data, err := s.source.ReadRanges([]Range{{Offset: position, Length: length}})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 1 || int64(len(data[0])) > length {
+		return nil, fmt.Errorf("block source returned an invalid range at offset %d", position)
+	}
+
+	return data[0], nil
+}
+
+// PatchFromSource applies delta like PatchWithOptions but reads the copy ranges from source. With
+// options.ReadAhead set, the ranges of upcoming copy commands are requested in the same ReadRanges call and cached
+// up to options.CacheSize bytes. A copy larger than options.CacheSize is requested in pieces of that size. Options
+// may be nil.
+func PatchFromSource(source BlockSource, newFile io.Writer, delta io.Reader, options *PatchOptions) error {
+	if options == nil {
+		options = &PatchOptions{}
+	}
+
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	return newPatcher(&blockSource{source: source, cache: spanCache{size: options.cacheSize()}}, newFile, reader, options).run()
+}
+
+// PlanRanges reads delta and returns the original file ranges of its copy commands in command order, so they can be
+// fetched before patching.
+func PlanRanges(delta io.Reader) ([]Range, error) {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []Range
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch command.Type {
+		case End:
+			return ranges, nil
+		case Copy:
+			ranges = append(ranges, Range{Offset: int64(command.Position), Length: int64(command.Length)})
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package rdiff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingBlockSource counts the ReadRanges requests and the ranges requested.
+type countingBlockSource struct {
+	BlockSource
+	requests int
+	ranges   []Range
+}
+
+func (s *countingBlockSource) ReadRanges(ranges []Range) ([][]byte, error) {
+	s.requests++
+	s.ranges = append(s.ranges, ranges...)
+
+	return s.BlockSource.ReadRanges(ranges)
+}
+
+func TestPatchFromSource(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		_, blockSize, _, originalFile, err := generateFile(10, 100)
+		assert.Nil(t, err)
+		newFile := generateSparseChanges(originalFile, int(blockSize)*3)
+
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		expectedRanges, err := PlanRanges(bytes.NewReader(delta.Bytes()))
+		assert.Nil(t, err)
+
+		// Adjacent block copies are merged, so the copies between two changes span 2 blocks. The cache holds a few
+		// of them, otherwise the current copy fills it and no upcoming range is requested with it.
+		for _, options := range []*PatchOptions{nil, {ReadAhead: 16}, {ReadAhead: 16, CacheSize: int64(blockSize * 2)}} {
+			source := &countingBlockSource{BlockSource: NewReaderAtSource(bytes.NewReader(originalFile))}
+			actualNewFile := &bytes.Buffer{}
+			err = PatchFromSource(source, actualNewFile, bytes.NewReader(delta.Bytes()), options)
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, actualNewFile.Bytes())
+
+			// Every planned range is requested once
+			assert.Equal(t, expectedRanges, source.ranges)
+			if options == nil {
+				assert.Equal(t, len(expectedRanges), source.requests)
+			} else {
+				assert.Less(t, source.requests, len(expectedRanges))
+			}
+		}
+	}
+}
+
+func TestPlanRanges(t *testing.T) {
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(1000, 100))
+	assert.Nil(t, writer.WriteLiteral([]byte{1, 2, 3}))
+	assert.Nil(t, writer.WriteCopy(0, 10))
+	assert.Nil(t, writer.WriteCopy(1<<40, 1<<20))
+	assert.Nil(t, writer.Close())
+
+	ranges, err := PlanRanges(delta)
+	assert.Nil(t, err)
+	assert.Equal(t, []Range{{1000, 100}, {0, 10}, {1 << 40, 1 << 20}}, ranges)
+}
+
+func TestReaderAtSource_ReadRanges(t *testing.T) {
+	originalFile, err := generateBytes(100000)
+	assert.Nil(t, err)
+
+	// A range length far past the end of the original file is read up to the end only
+	data, err := NewReaderAtSource(bytes.NewReader(originalFile)).ReadRanges([]Range{{10, 1000}, {90000, 1 << 50}, {200000, 10}})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{originalFile[10:1010], originalFile[90000:], nil}, data)
+}
+
+func TestPatchFromSource_LargeCopy(t *testing.T) {
+	originalFile, err := generateBytes(1000)
+	assert.Nil(t, err)
+
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(50, 950))
+	assert.Nil(t, writer.Close())
+
+	// A copy larger than the cache is requested in pieces
+	source := &countingBlockSource{BlockSource: NewReaderAtSource(bytes.NewReader(originalFile))}
+	actualNewFile := &bytes.Buffer{}
+	err = PatchFromSource(source, actualNewFile, delta, &PatchOptions{CacheSize: 300})
+	assert.Nil(t, err)
+	assert.Equal(t, originalFile[50:], actualNewFile.Bytes())
+	assert.Equal(t, []Range{{50, 300}, {350, 300}, {650, 300}, {950, 50}}, source.ranges)
+}
@@ -0,0 +1,76 @@
+package rdiff
+
+// buzhashBits is the size of the Buzhash values. Rotations by 64 bits would cancel equal bytes 64 positions apart,
+// and with them every block of a constant byte whose size is a multiple of 128. The prime 61 divides no common
+// block size.
+const buzhashBits = 61
+
+const buzhashMask = 1<<buzhashBits - 1
+
+// buzhashTable maps every byte to a random 61-bit value, generated with splitmix64 from BuzhashSeed.
+var buzhashTable = func() (table [256]uint64) {
+	state := BuzhashSeed
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = (z ^ (z >> 31)) & buzhashMask
+	}
+
+	return table
+}()
+
+// BuzhashChecksum is the cyclic polynomial rolling hash: the hash of a window is the xor of the table values of
+// its bytes, each rotated left within 61 bits by its distance to the end of the window. Every update is a table
+// lookup and a rotation, which also makes it suitable for content-defined chunking.
+type BuzhashChecksum struct {
+	count uint64
+	hash  uint64
+}
+
+func NewBuzhashChecksum() *BuzhashChecksum {
+	return &BuzhashChecksum{}
+}
+
+func (r *BuzhashChecksum) Update(buf []byte) {
+	hash := r.hash
+	for _, in := range buf {
+		hash = buzhashRotate(hash, 1) ^ buzhashTable[in]
+	}
+
+	r.hash = hash
+	r.count += uint64(len(buf))
+}
+
+func (r *BuzhashChecksum) Rotate(out, in byte) {
+	r.hash = buzhashRotate(r.hash, 1) ^ buzhashRotate(buzhashTable[out], r.count%buzhashBits) ^ buzhashTable[in]
+}
+
+func (r *BuzhashChecksum) Rollin(in byte) {
+	r.hash = buzhashRotate(r.hash, 1) ^ buzhashTable[in]
+	r.count++
+}
+
+func (r *BuzhashChecksum) Rollout(out byte) {
+	r.count--
+	r.hash ^= buzhashRotate(buzhashTable[out], r.count%buzhashBits)
+}
+
+// buzhashRotate rotates the 61-bit value x left by k < 61 bits.
+func buzhashRotate(x, k uint64) uint64 {
+	return (x<<k | x>>(buzhashBits-k)) & buzhashMask
+}
+
+func (r *BuzhashChecksum) Digest() uint64 {
+	return r.hash
+}
+
+func (r *BuzhashChecksum) Count() uint64 {
+	return r.count
+}
+
+func (r *BuzhashChecksum) Reset() {
+	r.count = 0
+	r.hash = 0
+}
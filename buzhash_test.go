@@ -0,0 +1,90 @@
+package rdiff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuzhashChecksum(t *testing.T) {
+	// The table is part of the signature format
+	assert.Equal(t, uint64(0x07c241a544eabe56), buzhashTable[0])
+	assert.Equal(t, uint64(0x19f26d39e6e3b0e1), buzhashTable[1])
+
+	r := NewBuzhashChecksum()
+	assert.Equal(t, uint64(0), r.Digest())
+
+	r.Rollin(0)
+	assert.Equal(t, buzhashTable[0], r.Digest())
+	r.Rollin(1)
+	assert.Equal(t, (buzhashTable[0]<<1|buzhashTable[0]>>60)&buzhashMask^buzhashTable[1], r.Digest())
+	assert.Equal(t, uint64(2), r.Count())
+
+	r.Rollout(0)
+	r.Rollout(1)
+	assert.Equal(t, uint64(0), r.Digest())
+	assert.Equal(t, uint64(0), r.Count())
+
+	data, err := generateBytes(1000)
+	assert.Nil(t, err)
+
+	// Rolling the window gives the checksum of the window, including windows longer than 61 bytes
+	for _, window := range []int{1, 60, 61, 62, 64, 65, 100} {
+		r.Reset()
+		r.Update(data[:window])
+		rotating := NewBuzhashChecksum()
+		rotating.Update(data[:window])
+		for i := window; i < len(data); i++ {
+			r.Rollin(data[i])
+			r.Rollout(data[i-window])
+			rotating.Rotate(data[i-window], data[i])
+
+			expected := NewBuzhashChecksum()
+			expected.Update(data[i-window+1 : i+1])
+			assert.Equal(t, expected.Digest(), r.Digest())
+			assert.Equal(t, expected.Digest(), rotating.Digest())
+		}
+	}
+}
+
+func TestBuzhashChecksum_Collisions(t *testing.T) {
+	const blockSize = 1024
+
+	checksum := func(block []byte) uint64 {
+		r := NewBuzhashChecksum()
+		r.Update(block)
+		return r.Digest()
+	}
+
+	// Blocks of a constant byte all have different checksums
+	checksums := make(map[uint64]byte)
+	for i := 0; i < 256; i++ {
+		block := bytes.Repeat([]byte{byte(i)}, blockSize)
+		previous, ok := checksums[checksum(block)]
+		assert.False(t, ok, "bytes %d and %d", previous, i)
+		checksums[checksum(block)] = byte(i)
+	}
+
+	// Equal bytes 64 or 128 positions apart do not cancel each other
+	block, err := generateBytes(blockSize)
+	assert.Nil(t, err)
+	for _, distance := range []int{64, 128, 512} {
+		changed := append([]byte{}, block...)
+		changed[100]++
+		changed[100+distance] = changed[100]
+		block[100+distance] = block[100]
+		assert.NotEqual(t, checksum(block), checksum(changed), distance)
+	}
+}
+
+func BenchmarkBuzhashChecksum_Update(b *testing.B) {
+	data, err := generateBytes(64 << 10)
+	assert.Nil(b, err)
+
+	b.SetBytes(int64(len(data)))
+	r := NewBuzhashChecksum()
+	for n := 0; n < b.N; n++ {
+		r.Update(data)
+	}
+}
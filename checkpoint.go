@@ -0,0 +1,265 @@
+package rdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// DefaultCheckpointInterval is the number of bytes written between checkpoints when
+// PatchOptions.CheckpointInterval is not set.
+const DefaultCheckpointInterval = 64 << 20
+
+// fingerprintSampleSize and fingerprintSamples bound the part of a file read to fingerprint it.
+const (
+	fingerprintSampleSize = 64 << 10
+	fingerprintSamples    = 64
+)
+
+// Fingerprint identifies the content of a file by its size and the SHA-256 hash of its data. Files larger than
+// fingerprintSamples*fingerprintSampleSize bytes are only sampled: the hash covers fingerprintSamples evenly spaced
+// ranges including the first and the last one, so fingerprinting reads at most 4 MiB of any file. A change outside
+// the sampled ranges of a file of the same size is not detected.
+type Fingerprint struct {
+	Size int64
+	Hash [sha256.Size]byte
+}
+
+// fingerprint returns the fingerprint of file and seeks it back to the start.
+func fingerprint(file io.ReadSeeker) (Fingerprint, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	hash := sha256.New()
+	if size <= fingerprintSamples*fingerprintSampleSize {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return Fingerprint{}, err
+		}
+		if _, err = io.CopyN(hash, file, size); err != nil {
+			return Fingerprint{}, noEOF(err)
+		}
+	} else {
+		for i := int64(0); i < fingerprintSamples; i++ {
+			offset := i * (size - fingerprintSampleSize) / (fingerprintSamples - 1)
+			if _, err = file.Seek(offset, io.SeekStart); err != nil {
+				return Fingerprint{}, err
+			}
+			if _, err = io.CopyN(hash, file, fingerprintSampleSize); err != nil {
+				return Fingerprint{}, noEOF(err)
+			}
+		}
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return Fingerprint{}, err
+	}
+
+	result := Fingerprint{Size: size}
+	hash.Sum(result.Hash[:0])
+
+	return result, nil
+}
+
+// Checkpoint records how far a patch got. A checkpoint may be taken in the middle of a command, CommandProgress is
+// then the number of bytes of the command already written.
+type Checkpoint struct {
+	// Delta and Original identify the files the patch was applied with.
+	Delta    Fingerprint
+	Original Fingerprint
+
+	// DeltaOffset is the position in the delta file of the next command.
+	DeltaOffset int64
+
+	// OutputOffset is the number of bytes of the new file written so far.
+	OutputOffset int64
+
+	// CommandIndex is the index of the next command.
+	CommandIndex int64
+
+	// CommandProgress is the number of bytes of the next command already written.
+	CommandProgress int64
+}
+
+// CheckpointStore keeps the last checkpoint of a patch.
+type CheckpointStore interface {
+	Save(checkpoint *Checkpoint) error
+
+	// Load returns the last saved checkpoint, or nil when there is none.
+	Load() (*Checkpoint, error)
+
+	// Remove deletes the checkpoint once the patch is complete.
+	Remove() error
+}
+
+// FileCheckpointStore keeps the checkpoint in a sidecar file at Path.
+type FileCheckpointStore struct {
+	Path string
+}
+
+func (s *FileCheckpointStore) Save(checkpoint *Checkpoint) error {
+	buffer := &bytes.Buffer{}
+	if err := binary.Write(buffer, binary.BigEndian, checkpoint); err != nil {
+		return err
+	}
+
+	tempPath := s.Path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(buffer.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, s.Path)
+}
+
+func (s *FileCheckpointStore) Load() (*Checkpoint, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{}
+	if err = binary.Read(bytes.NewReader(data), binary.BigEndian, checkpoint); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+func (s *FileCheckpointStore) Remove() error {
+	if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// checkpointInterval returns the number of bytes between checkpoints.
+func (p *patcher) checkpointInterval() uint64 {
+	if p.options.CheckpointInterval == 0 {
+		return DefaultCheckpointInterval
+	}
+
+	return uint64(p.options.CheckpointInterval)
+}
+
+// checkpoint flushes and syncs the new file and saves a checkpoint once CheckpointInterval bytes were written since
+// the last one. The patch is to continue at progress bytes into command index at deltaOffset.
+func (p *patcher) checkpoint(deltaOffset, index int64, progress uint64) error {
+	store := p.options.Checkpoints
+	if store == nil || p.outputSize-p.checkpointSize < p.checkpointInterval() {
+		return nil
+	}
+
+	if flusher, ok := p.newFile.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	if syncer, ok := p.newFile.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			return err
+		}
+	}
+
+	checkpoint := &Checkpoint{
+		Delta:           p.deltaFingerprint,
+		Original:        p.originalFingerprint,
+		DeltaOffset:     deltaOffset,
+		OutputOffset:    int64(p.outputSize),
+		CommandIndex:    index,
+		CommandProgress: int64(progress),
+	}
+	if err := store.Save(checkpoint); err != nil {
+		return err
+	}
+	p.checkpointSize = p.outputSize
+
+	return nil
+}
+
+// finish removes the checkpoint of a complete patch.
+func (p *patcher) finish() error {
+	if p.options.Checkpoints == nil {
+		return nil
+	}
+
+	return p.options.Checkpoints.Remove()
+}
+
+// ResumePatch applies delta like PatchWithOptions and saves checkpoints to options.Checkpoints. When the store holds
+// a checkpoint of an interrupted run, the patch continues from it: delta is positioned at the next command, the bytes
+// of that command already written are skipped, and newFile is positioned at the checkpoint output offset and, if it
+// has a Truncate method, truncated there. The checkpoint is removed once the patch is complete.
+//
+// The original file and delta are fingerprinted up front, reading at most 4 MiB of each, and ErrCheckpointMismatch is
+// returned when they differ from the files of the checkpoint. Only ResumePatch writes checkpoints that can be resumed,
+// PatchWithOptions ignores options.Checkpoints.
+func ResumePatch(originalFile io.ReadSeeker, newFile io.WriteSeeker, delta io.ReadSeeker, options *PatchOptions) error {
+	if options == nil || options.Checkpoints == nil {
+		return errors.New("resuming a patch requires a checkpoint store")
+	}
+
+	checkpoint, err := options.Checkpoints.Load()
+	if err != nil {
+		return err
+	}
+
+	deltaFingerprint, err := fingerprint(delta)
+	if err != nil {
+		return err
+	}
+	originalFingerprint, err := fingerprint(originalFile)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil && (checkpoint.Delta != deltaFingerprint || checkpoint.Original != originalFingerprint) {
+		return ErrCheckpointMismatch
+	}
+
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	p := newPatcher(newSeekSource(originalFile, options), newFile, reader, options)
+	p.deltaFingerprint = deltaFingerprint
+	p.originalFingerprint = originalFingerprint
+	if checkpoint != nil {
+		if _, err = delta.Seek(checkpoint.DeltaOffset, io.SeekStart); err != nil {
+			return err
+		}
+		reader.in.count = checkpoint.DeltaOffset
+		reader.offset = checkpoint.DeltaOffset
+		reader.index = checkpoint.CommandIndex - 1
+		p.outputSize = uint64(checkpoint.OutputOffset)
+		p.checkpointSize = p.outputSize
+		p.progress = uint64(checkpoint.CommandProgress)
+	}
+
+	if truncater, ok := newFile.(interface{ Truncate(size int64) error }); ok {
+		if err = truncater.Truncate(int64(p.outputSize)); err != nil {
+			return err
+		}
+	}
+	if _, err = newFile.Seek(int64(p.outputSize), io.SeekStart); err != nil {
+		return err
+	}
+
+	return p.run()
+}
@@ -0,0 +1,222 @@
+package rdiff
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errCrash = errors.New("crash")
+
+// crashingFile fails every write once limit bytes were written.
+type crashingFile struct {
+	file  *os.File
+	limit int64
+}
+
+func (f *crashingFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *crashingFile) Write(p []byte) (int, error) {
+	if int64(len(p)) > f.limit {
+		n, _ := f.file.Write(p[:f.limit])
+		f.limit = 0
+		return n, errCrash
+	}
+	f.limit -= int64(len(p))
+
+	return f.file.Write(p)
+}
+
+func TestResumePatch(t *testing.T) {
+	for i, checksumType := range ChecksumTypes {
+		// Generate file
+		_, blockSize, _, originalFile, err := generateFile(10, 100)
+		assert.Nil(t, err)
+
+		// Insert random bytes at the beginning of the file
+		insertData, err := generateBytes(rand64(1, 1000))
+		assert.Nil(t, err)
+		newFile := append(append([]byte{}, insertData...), originalFile...)
+
+		// Calculate delta
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize/3))
+		assert.Nil(t, err)
+
+		directory := t.TempDir()
+		store := &FileCheckpointStore{Path: filepath.Join(directory, "checkpoint")}
+		options := &PatchOptions{Checkpoints: store, CheckpointInterval: int64(blockSize * 2), ReadAhead: i % 2 * 16}
+		output, err := os.Create(filepath.Join(directory, "output"))
+		assert.Nil(t, err)
+
+		// Crash in the middle of the patch
+		crashOffset := rand64(int(blockSize*3), len(newFile)-1)
+		err = ResumePatch(bytes.NewReader(originalFile), &crashingFile{output, int64(crashOffset)}, bytes.NewReader(delta.Bytes()), options)
+		assert.Equal(t, errCrash, err)
+
+		checkpoint, err := store.Load()
+		assert.Nil(t, err)
+		assert.NotNil(t, checkpoint)
+		assert.LessOrEqual(t, checkpoint.OutputOffset, int64(crashOffset))
+		assert.Greater(t, checkpoint.OutputOffset, int64(crashOffset-blockSize*3))
+
+		// Resume the patch
+		err = ResumePatch(bytes.NewReader(originalFile), output, bytes.NewReader(delta.Bytes()), options)
+		assert.Nil(t, err)
+		assert.Nil(t, output.Close())
+
+		actualNewFile, err := os.ReadFile(output.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, actualNewFile)
+
+		// The checkpoint of the complete patch is removed
+		checkpoint, err = store.Load()
+		assert.Nil(t, err)
+		assert.Nil(t, checkpoint)
+	}
+}
+
+func TestResumePatch_WithinCommand(t *testing.T) {
+	originalFile, err := generateBytes(10000)
+	assert.Nil(t, err)
+	literalData, err := generateBytes(10000)
+	assert.Nil(t, err)
+
+	// A single copy and a single literal, each longer than the checkpoint interval
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(0, 10000))
+	assert.Nil(t, writer.WriteLiteral(literalData))
+	assert.Nil(t, writer.Close())
+	newFile := append(append([]byte{}, originalFile...), literalData...)
+
+	for _, crashOffset := range []int64{4500, 14500} {
+		directory := t.TempDir()
+		store := &FileCheckpointStore{Path: filepath.Join(directory, "checkpoint")}
+		options := &PatchOptions{Checkpoints: store, CheckpointInterval: 1000}
+		output, err := os.Create(filepath.Join(directory, "output"))
+		assert.Nil(t, err)
+
+		err = ResumePatch(bytes.NewReader(originalFile), &crashingFile{output, crashOffset}, bytes.NewReader(delta.Bytes()), options)
+		assert.Equal(t, errCrash, err)
+
+		// The checkpoint is in the middle of the command
+		checkpoint, err := store.Load()
+		assert.Nil(t, err)
+		assert.Equal(t, crashOffset/1000*1000, checkpoint.OutputOffset)
+		assert.Equal(t, crashOffset/1000*1000%10000, checkpoint.CommandProgress)
+
+		err = ResumePatch(bytes.NewReader(originalFile), output, bytes.NewReader(delta.Bytes()), options)
+		assert.Nil(t, err)
+		assert.Nil(t, output.Close())
+
+		actualNewFile, err := os.ReadFile(output.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, actualNewFile)
+	}
+}
+
+func TestResumePatch_Mismatch(t *testing.T) {
+	_, blockSize, _, originalFile, err := generateFile(10, 100)
+	assert.Nil(t, err)
+	newFile := generateSparseChanges(originalFile, int(blockSize*2))
+	delta, err := generateDelta(originalFile, newFile, uint32(blockSize), ChecksumTypes[0], 8, uint32(blockSize/3))
+	assert.Nil(t, err)
+
+	directory := t.TempDir()
+	store := &FileCheckpointStore{Path: filepath.Join(directory, "checkpoint")}
+	options := &PatchOptions{Checkpoints: store, CheckpointInterval: int64(blockSize)}
+	output, err := os.Create(filepath.Join(directory, "output"))
+	assert.Nil(t, err)
+	defer output.Close()
+
+	err = ResumePatch(bytes.NewReader(originalFile), &crashingFile{output, int64(blockSize * 5)}, bytes.NewReader(delta.Bytes()), options)
+	assert.Equal(t, errCrash, err)
+
+	// Another original file or delta
+	changedOriginalFile := append([]byte{}, originalFile...)
+	changedOriginalFile[0]++
+	err = ResumePatch(bytes.NewReader(changedOriginalFile), output, bytes.NewReader(delta.Bytes()), options)
+	assert.True(t, errors.Is(err, ErrCheckpointMismatch))
+	err = ResumePatch(bytes.NewReader(originalFile), output, bytes.NewReader(append(delta.Bytes()[:4:4], 0)), options)
+	assert.True(t, errors.Is(err, ErrCheckpointMismatch))
+}
+
+func TestPatchWithOptions_IgnoresCheckpoints(t *testing.T) {
+	_, blockSize, _, originalFile, err := generateFile(10, 100)
+	assert.Nil(t, err)
+	newFile := generateSparseChanges(originalFile, int(blockSize*2))
+	delta, err := generateDelta(originalFile, newFile, uint32(blockSize), ChecksumTypes[0], 8, uint32(blockSize/3))
+	assert.Nil(t, err)
+
+	store := &FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoint")}
+	options := &PatchOptions{Checkpoints: store, CheckpointInterval: int64(blockSize)}
+	output, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	assert.Nil(t, err)
+	defer output.Close()
+
+	err = PatchWithOptions(bytes.NewReader(originalFile), &crashingFile{output, int64(blockSize * 5)}, bytes.NewReader(delta.Bytes()), options)
+	assert.Equal(t, errCrash, err)
+	checkpoint, err := store.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+	assert.Equal(t, store, options.Checkpoints)
+}
+
+func TestFingerprint_Sampled(t *testing.T) {
+	file, err := generateBytes(fingerprintSamples*fingerprintSampleSize + 12345)
+	assert.Nil(t, err)
+	expected, err := fingerprint(bytes.NewReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(file)), expected.Size)
+
+	// Changes in the first, a middle and the last sample
+	middle := fingerprintSamples / 2 * (len(file) - fingerprintSampleSize) / (fingerprintSamples - 1)
+	for _, offset := range []int{0, middle, len(file) - 1} {
+		changedFile := append([]byte{}, file...)
+		changedFile[offset]++
+		actual, err := fingerprint(bytes.NewReader(changedFile))
+		assert.Nil(t, err)
+		assert.NotEqual(t, expected, actual, offset)
+	}
+
+	// The file is left at the start
+	reader := bytes.NewReader(file)
+	_, err = fingerprint(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, len(file), reader.Len())
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	store := &FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoint")}
+
+	checkpoint, err := store.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+
+	expectedCheckpoint := &Checkpoint{
+		Delta:           Fingerprint{Size: 1 << 30, Hash: [32]byte{1, 2, 3}},
+		Original:        Fingerprint{Size: 1 << 35, Hash: [32]byte{4, 5, 6}},
+		DeltaOffset:     1 << 40,
+		OutputOffset:    1 << 50,
+		CommandIndex:    12345,
+		CommandProgress: 678,
+	}
+	assert.Nil(t, store.Save(&Checkpoint{DeltaOffset: 1, OutputOffset: 2, CommandIndex: 3}))
+	assert.Nil(t, store.Save(expectedCheckpoint))
+	checkpoint, err = store.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, expectedCheckpoint, checkpoint)
+
+	assert.Nil(t, store.Remove())
+	assert.Nil(t, store.Remove())
+	checkpoint, err = store.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+}
@@ -2,109 +2,128 @@ package rdiff
 
 import (
 	"fmt"
-	"golang.org/x/crypto/blake2b"
-	"golang.org/x/crypto/md4"
 )
 
+// Checksum computes the weak and strong checksums of a checksum type. The algorithms are looked up once when the
+// Checksum is created and the hash objects are reused for every block, so a Checksum is not safe for concurrent use.
+// WriteSignature, WriteDelta and Patch create their own.
 type Checksum struct {
 	checksumType ChecksumType
-	rollsum      *RollingChecksum
-	rabinkarp    *RabinkarpChecksum
+	algorithm    *ChecksumAlgorithm
+	key          []byte
+
+	// rollingHash is the weak checksum of the rolling window, blockHash computes the weak checksum of whole blocks.
+	rollingHash RollingHash
+	blockHash   RollingHash
+
+	// strongHash and strongSum are reset and reused for every strong checksum.
+	strongHash StrongHash
+	strongSum  []byte
 }
 
+// NewChecksum returns the checksum of checksumType. Keyed checksum types need NewKeyedChecksum.
 func NewChecksum(checksumType ChecksumType) (*Checksum, error) {
-	switch checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		return &Checksum{checksumType: checksumType, rollsum: NewRollingChecksum()}, nil
-	case Rabinkarp_Md4, Rabinkarp_Blake2b:
-		return &Checksum{checksumType: checksumType, rabinkarp: NewRabinkarpChecksum()}, nil
-	default:
-		return nil, fmt.Errorf("unexpected checksum type %#x", checksumType)
+	return NewKeyedChecksum(checksumType, nil)
+}
+
+// NewKeyedChecksum returns the checksum of checksumType with its strong checksum keyed with key. The key must have
+// the KeySize of the checksum type, nil for checksum types without a key.
+func NewKeyedChecksum(checksumType ChecksumType, key []byte) (*Checksum, error) {
+	algorithm, err := LookupChecksumAlgorithm(checksumType)
+	if err != nil {
+		return nil, err
 	}
+
+	if uint32(len(key)) != algorithm.KeySize {
+		return nil, fmt.Errorf("%w: %d bytes for checksum type %#x, expected %d", ErrInvalidChecksumKey, len(key), checksumType, algorithm.KeySize)
+	}
+
+	return &Checksum{
+		checksumType: checksumType,
+		algorithm:    algorithm,
+		key:          key,
+		rollingHash:  algorithm.NewRollingHash(),
+		blockHash:    algorithm.NewRollingHash(),
+		strongHash:   algorithm.NewStrongHash(key),
+	}, nil
 }
 
 func (c *Checksum) MaxStrongChecksumSize() uint32 {
-	switch c.checksumType {
-	case Rollsum_Md4, Rabinkarp_Md4:
-		return Md4ChecksumMaxSize
-	default:
-		return Blake2bChecksumMaxSize
+	return uint32(c.strongHash.Size())
+}
+
+// WeakChecksumSize returns the size in bytes of the weak checksums in the signature.
+func (c *Checksum) WeakChecksumSize() uint32 {
+	if c.algorithm.WeakSize == 0 {
+		return 4
 	}
+
+	return c.algorithm.WeakSize
 }
 
+// CalculateWeakChecksum returns the weak checksum of data. Weak checksums of 8 bytes are truncated to their low 32
+// bits, CalculateWeakChecksum64 returns them whole.
 func (c *Checksum) CalculateWeakChecksum(data []byte) uint32 {
-	switch c.checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		rc := NewRollingChecksum()
-		rc.Update(data)
-		return rc.Digest()
-	default:
-		rc := NewRabinkarpChecksum()
-		rc.Update(data)
-		return rc.Digest()
-	}
+	return uint32(c.CalculateWeakChecksum64(data))
+}
+
+// CalculateWeakChecksum64 returns the weak checksum of data as stored in the signature.
+func (c *Checksum) CalculateWeakChecksum64(data []byte) uint64 {
+	c.blockHash.Reset()
+	c.blockHash.Update(data)
+	return c.blockHash.Digest()
 }
 
+// CalculateStrongChecksum returns the first checksumSize bytes of the strong checksum of data in a new slice.
 func (c *Checksum) CalculateStrongChecksum(data []byte, checksumSize uint32) ([]byte, error) {
-	var checksum []byte
-	switch c.checksumType {
-	case Rollsum_Md4, Rabinkarp_Md4:
-		h := md4.New()
-		h.Write(data)
-		checksum = h.Sum(nil)
-	default:
-		s := blake2b.Sum256(data)
-		checksum = s[:]
+	checksum, err := c.strongChecksum(data, checksumSize)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(checksum) < int(checksumSize) {
-		return nil, fmt.Errorf("strong checksum size %d exceeds actual size %d for checksum type %#x", checksumSize, len(checksum), c.checksumType)
+	return append([]byte(nil), checksum...), nil
+}
+
+// strongChecksum is CalculateStrongChecksum without the copy, the result is overwritten by the next call.
+func (c *Checksum) strongChecksum(data []byte, checksumSize uint32) ([]byte, error) {
+	c.strongHash.Reset()
+	c.strongHash.Write(data)
+	c.strongSum = c.strongHash.Sum(c.strongSum[:0])
+
+	if len(c.strongSum) < int(checksumSize) {
+		return nil, fmt.Errorf("%w: %d exceeds actual size %d for checksum type %#x", ErrInvalidStrongChecksumSize, checksumSize, len(c.strongSum), c.checksumType)
 	}
 
-	return checksum[:checksumSize], nil
+	return c.strongSum[:checksumSize], nil
 }
 
 func (c *Checksum) Rollin(in byte) {
-	switch c.checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		c.rollsum.Rollin(in)
-	default:
-		c.rabinkarp.Rollin(in)
-	}
+	c.rollingHash.Rollin(in)
 }
 
 func (c *Checksum) Rollout(out byte) {
-	switch c.checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		c.rollsum.Rollout(out)
-	default:
-		c.rabinkarp.Rollout(out)
-	}
+	c.rollingHash.Rollout(out)
+}
+
+// Rotate is Rollout of out followed by Rollin of in in a single step.
+func (c *Checksum) Rotate(out, in byte) {
+	c.rollingHash.Rotate(out, in)
 }
 
+// Digest returns the weak checksum of the rolling window, truncated like CalculateWeakChecksum.
 func (c *Checksum) Digest() uint32 {
-	switch c.checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		return c.rollsum.Digest()
-	default:
-		return c.rabinkarp.Digest()
-	}
+	return uint32(c.rollingHash.Digest())
+}
+
+// Digest64 returns the weak checksum of the rolling window as stored in the signature.
+func (c *Checksum) Digest64() uint64 {
+	return c.rollingHash.Digest()
 }
 
 func (c *Checksum) Reset() {
-	switch c.checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		c.rollsum.Reset()
-	default:
-		c.rabinkarp.Reset()
-	}
+	c.rollingHash.Reset()
 }
 
 func (c *Checksum) Count() uint64 {
-	switch c.checksumType {
-	case Rollsum_Md4, Rollsum_Blake2b:
-		return c.rollsum.count
-	default:
-		return c.rabinkarp.count
-	}
+	return c.rollingHash.Count()
 }
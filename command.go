@@ -0,0 +1,388 @@
+package rdiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+func getByteSize(value uint64) byte {
+	if (value >> 32) > 0 {
+		return 8
+	} else if (value >> 16) > 0 {
+		return 4
+	} else if (value >> 8) > 0 {
+		return 2
+	} else {
+		return 1
+	}
+}
+
+func getCommandOffset(size byte) byte {
+	switch size {
+	case 1:
+		return 0
+	case 2:
+		return 1
+	case 4:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func getParamSize(commandOffset byte) byte {
+	switch commandOffset {
+	case 0:
+		return 1
+	case 1:
+		return 2
+	case 2:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func writeParam(out io.Writer, value uint64, size uint8) error {
+	switch size {
+	case 1:
+		return binary.Write(out, binary.BigEndian, uint8(value))
+	case 2:
+		return binary.Write(out, binary.BigEndian, uint16(value))
+	case 4:
+		return binary.Write(out, binary.BigEndian, uint32(value))
+	case 8:
+		return binary.Write(out, binary.BigEndian, value)
+	}
+
+	return fmt.Errorf("invalid data size: %v", size)
+}
+
+func readParam(in io.Reader, commandOffset byte) (uint64, error) {
+	switch getParamSize(commandOffset) {
+	case 1:
+		var value uint8
+		err := binary.Read(in, binary.BigEndian, &value)
+		return uint64(value), err
+	case 2:
+		var value uint16
+		err := binary.Read(in, binary.BigEndian, &value)
+		return uint64(value), err
+	case 4:
+		var value uint32
+		err := binary.Read(in, binary.BigEndian, &value)
+		return uint64(value), err
+	default:
+		var value uint64
+		err := binary.Read(in, binary.BigEndian, &value)
+		return value, err
+	}
+}
+
+type Command struct {
+	commandType    CommandType
+	position       uint64
+	length         uint64
+	literalData    []byte
+	maxLiteralSize uint32
+}
+
+// writeCommandHeader writes the command code and parameters. The literal data of a literal command is not written.
+func writeCommandHeader(out io.Writer, commandType CommandType, position, length uint64) error {
+	switch commandType {
+	case Literal:
+		if length == 0 {
+			return ErrEmptyLiteral
+		} else if length < uint64(MinParameterizedLiteralCommand) {
+			_, err := out.Write([]byte{byte(length)})
+			return err
+		}
+
+		byteSize := getByteSize(length)
+		offset := getCommandOffset(byteSize)
+		commandCode := MinParameterizedLiteralCommand + offset
+		if _, err := out.Write([]byte{commandCode}); err != nil {
+			return err
+		}
+
+		return writeParam(out, length, byteSize)
+	case Copy:
+		positionByteSize := getByteSize(position)
+		lengthByteSize := getByteSize(length)
+		positionOffset := getCommandOffset(positionByteSize)
+		lengthOffset := getCommandOffset(lengthByteSize)
+
+		commandCode := MinCopyCommand + positionOffset*4 + lengthOffset
+		if _, err := out.Write([]byte{commandCode}); err != nil {
+			return err
+		}
+
+		if err := writeParam(out, position, positionByteSize); err != nil {
+			return err
+		}
+
+		return writeParam(out, length, lengthByteSize)
+	case End:
+		_, err := out.Write([]byte{0})
+		return err
+	}
+
+	return fmt.Errorf("unsupported command type %d", commandType)
+}
+
+// commandHeaderSize returns the number of bytes writeCommandHeader writes for a command.
+func commandHeaderSize(commandType CommandType, position, length uint64) uint64 {
+	switch commandType {
+	case Literal:
+		if length < uint64(MinParameterizedLiteralCommand) {
+			return 1
+		}
+		return 1 + uint64(getByteSize(length))
+	case Copy:
+		return 1 + uint64(getByteSize(position)) + uint64(getByteSize(length))
+	default:
+		return 1
+	}
+}
+
+func writeCommand(out io.Writer, command *Command) error {
+	if err := writeCommandHeader(out, command.commandType, command.position, command.length); err != nil {
+		return err
+	}
+
+	if command.commandType == Literal {
+		if _, err := out.Write(command.literalData); err != nil {
+			return err
+		}
+		command.literalData = make([]byte, 0, command.maxLiteralSize)
+		command.length = 0
+	}
+
+	return nil
+}
+
+func readMagicNumber(delta io.Reader) error {
+	var deltaFormat uint32
+	if err := binary.Read(delta, binary.BigEndian, &deltaFormat); err != nil {
+		return err
+	}
+	if deltaFormat != DeltaMagicNumber {
+		return fmt.Errorf("%w %#x, expected %#x", ErrBadMagic, deltaFormat, DeltaMagicNumber)
+	}
+
+	return nil
+}
+
+// readCommand reads the next command header from delta. The literal data of a literal command is left in delta.
+func readCommand(delta io.Reader) (*DeltaCommand, error) {
+	var cmdCode byte
+	if err := binary.Read(delta, binary.BigEndian, &cmdCode); err != nil {
+		return nil, err
+	}
+
+	if CommandType(cmdCode) == End {
+		return &DeltaCommand{Type: End}, nil
+	} else if cmdCode >= MinReservedCommand {
+		return nil, fmt.Errorf("%w %d", ErrUnknownCommand, cmdCode)
+	}
+
+	var err error
+	var position, length uint64
+	if cmdCode < MinParameterizedLiteralCommand {
+		return &DeltaCommand{Type: Literal, Length: uint64(cmdCode)}, nil
+	} else if cmdCode < MinCopyCommand {
+		if length, err = readParam(delta, cmdCode-MinParameterizedLiteralCommand); err != nil {
+			return nil, err
+		}
+		return &DeltaCommand{Type: Literal, Length: length}, nil
+	}
+
+	offset := cmdCode - MinCopyCommand
+	positionOffset := offset / 4
+	lengthOffset := offset - positionOffset*4
+	if position, err = readParam(delta, positionOffset); err != nil {
+		return nil, err
+	}
+	if length, err = readParam(delta, lengthOffset); err != nil {
+		return nil, err
+	}
+
+	return &DeltaCommand{Type: Copy, Position: position, Length: length}, nil
+}
+
+// DeltaCommand is a command of a delta file.
+type DeltaCommand struct {
+	Type CommandType
+
+	// Position is the offset in the original file a copy command copies from.
+	Position uint64
+
+	// Length is the number of bytes a literal or copy command adds to the new file.
+	Length uint64
+
+	// Data reads the payload of a literal command. It is only valid until the next call to DeltaReader.Next.
+	Data io.Reader
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	in    io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	r.count += int64(n)
+
+	return n, err
+}
+
+// literalReader reads the payload of a literal command and reports a premature end of the delta file as a
+// *DeltaError of the command.
+type literalReader struct {
+	in        io.Reader
+	remaining int64
+	offset    int64
+	index     int64
+}
+
+func (r *literalReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.in.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = &DeltaError{Offset: r.offset, Command: r.index, Err: ErrTruncatedDelta}
+	} else if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+// DeltaReader decodes the commands of a delta file one by one. Problems in the delta file are reported as
+// *DeltaError.
+type DeltaReader struct {
+	in     *countingReader
+	data   *literalReader
+	end    bool
+	offset int64
+	index  int64
+}
+
+// NewDeltaReader checks the delta magic number and returns a reader positioned at the first command.
+func NewDeltaReader(in io.Reader) (*DeltaReader, error) {
+	counter := &countingReader{in: in}
+	if err := readMagicNumber(counter); err != nil {
+		return nil, deltaError(err, 0, -1)
+	}
+
+	return &DeltaReader{in: counter, offset: counter.count, index: -1}, nil
+}
+
+// Offset returns the position in the delta file of the command last read by Next.
+func (r *DeltaReader) Offset() int64 {
+	return r.offset
+}
+
+// Index returns the index of the command last read by Next. The first command has index 0.
+func (r *DeltaReader) Index() int64 {
+	return r.index
+}
+
+// Next returns the next command. The unread part of the previous literal payload is skipped. After the end
+// command has been returned Next returns io.EOF.
+func (r *DeltaReader) Next() (*DeltaCommand, error) {
+	if r.end {
+		return nil, io.EOF
+	}
+
+	if r.data != nil && r.data.remaining > 0 {
+		if _, err := io.Copy(io.Discard, r.data); err != nil {
+			return nil, deltaError(err, r.offset, r.index)
+		}
+	}
+	r.data = nil
+	r.offset = r.in.count
+	r.index++
+
+	command, err := readCommand(r.in)
+	if err != nil {
+		return nil, deltaError(err, r.offset, r.index)
+	}
+
+	if command.Position > math.MaxInt64 || command.Length > math.MaxInt64-command.Position {
+		return nil, deltaError(ErrParameterOverflow, r.offset, r.index)
+	}
+
+	switch command.Type {
+	case End:
+		r.end = true
+	case Literal:
+		r.data = &literalReader{in: r.in, remaining: int64(command.Length), offset: r.offset, index: r.index}
+		command.Data = r.data
+	}
+
+	return command, nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF since the data must not end before the expected length.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// DeltaWriter encodes commands into a delta file.
+type DeltaWriter struct {
+	out io.Writer
+}
+
+// NewDeltaWriter writes the delta magic number and returns a writer for the commands.
+func NewDeltaWriter(out io.Writer) (*DeltaWriter, error) {
+	if err := binary.Write(out, binary.BigEndian, DeltaMagicNumber); err != nil {
+		return nil, err
+	}
+
+	return &DeltaWriter{out: out}, nil
+}
+
+// WriteLiteral writes a literal command with data as payload.
+func (w *DeltaWriter) WriteLiteral(data []byte) error {
+	if err := writeCommandHeader(w.out, Literal, 0, uint64(len(data))); err != nil {
+		return err
+	}
+
+	_, err := w.out.Write(data)
+	return err
+}
+
+// WriteLiteralFrom writes a literal command with the next length bytes of in as payload.
+func (w *DeltaWriter) WriteLiteralFrom(in io.Reader, length uint64) error {
+	if err := writeCommandHeader(w.out, Literal, 0, length); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(w.out, in, int64(length))
+	return err
+}
+
+// WriteCopy writes a copy command of length bytes from position of the original file.
+func (w *DeltaWriter) WriteCopy(position, length uint64) error {
+	return writeCommandHeader(w.out, Copy, position, length)
+}
+
+// Close writes the end command. It does not close the underlying writer.
+func (w *DeltaWriter) Close() error {
+	return writeCommandHeader(w.out, End, 0, 0)
+}
@@ -0,0 +1,122 @@
+package rdiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaWriter(t *testing.T) {
+	literalData, err := generateBytes(rand64(65, 70000))
+	assert.Nil(t, err)
+	copyPosition := rand64(0, 1<<40)
+	copyLength := rand64(1, 1<<20)
+
+	// Generate expected delta
+	expectedDelta := &bytes.Buffer{}
+	err = binary.Write(expectedDelta, binary.BigEndian, DeltaMagicNumber)
+	assert.Nil(t, err)
+	err = writeCommand(expectedDelta, &Command{commandType: Literal, length: uint64(len(literalData)), literalData: literalData})
+	assert.Nil(t, err)
+	err = writeCommand(expectedDelta, &Command{commandType: Copy, position: copyPosition, length: copyLength})
+	assert.Nil(t, err)
+	err = writeCommand(expectedDelta, &Command{commandType: Literal, length: 3, literalData: literalData[:3]})
+	assert.Nil(t, err)
+	expectedDelta.WriteByte(0)
+
+	// Write actual delta
+	actualDelta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(actualDelta)
+	assert.Nil(t, err)
+	err = writer.WriteLiteral(literalData)
+	assert.Nil(t, err)
+	err = writer.WriteCopy(copyPosition, copyLength)
+	assert.Nil(t, err)
+	err = writer.WriteLiteralFrom(bytes.NewReader(literalData), 3)
+	assert.Nil(t, err)
+	err = writer.Close()
+	assert.Nil(t, err)
+
+	assert.Equal(t, expectedDelta, actualDelta)
+
+	// Empty literals cannot be encoded
+	assert.NotNil(t, writer.WriteLiteral(nil))
+}
+
+func TestDeltaReader(t *testing.T) {
+	literalData, err := generateBytes(rand64(65, 70000))
+	assert.Nil(t, err)
+	copyPosition := rand64(0, 1<<40)
+	copyLength := rand64(1, 1<<20)
+
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral(literalData))
+	assert.Nil(t, writer.WriteCopy(copyPosition, copyLength))
+	assert.Nil(t, writer.WriteLiteral(literalData[:10]))
+	assert.Nil(t, writer.Close())
+
+	reader, err := NewDeltaReader(delta)
+	assert.Nil(t, err)
+
+	// The payload of the first literal is read
+	command, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Literal, command.Type)
+	assert.Equal(t, uint64(len(literalData)), command.Length)
+	actualLiteralData, err := io.ReadAll(command.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, literalData, actualLiteralData)
+
+	command, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, &DeltaCommand{Type: Copy, Position: copyPosition, Length: copyLength}, command)
+
+	// The payload of the second literal is partially read and the rest is skipped
+	command, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Literal, command.Type)
+	assert.Equal(t, uint64(10), command.Length)
+	_, err = command.Data.Read(make([]byte, 4))
+	assert.Nil(t, err)
+
+	command, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, End, command.Type)
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDeltaReader_InvalidDelta(t *testing.T) {
+	// Invalid magic number
+	_, err := NewDeltaReader(bytes.NewReader([]byte{0x72, 0x73, 0x01, 0x36, 0}))
+	assert.NotNil(t, err)
+
+	// Reserved command code
+	reader, err := NewDeltaReader(bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, MinReservedCommand}))
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.NotNil(t, err)
+
+	// Missing end command
+	reader, err = NewDeltaReader(bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 2, 0xaa, 0xbb}))
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.True(t, errors.Is(err, ErrTruncatedDelta))
+
+	// Truncated literal data
+	reader, err = NewDeltaReader(bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 2, 0xaa}))
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.True(t, errors.Is(err, ErrTruncatedDelta))
+}
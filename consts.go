@@ -10,6 +10,12 @@ const (
 	// Blake2bChecksumMaxSize is the max size in bytes of BLAKE2B strong checksum
 	Blake2bChecksumMaxSize uint32 = 32
 
+	// Blake2bKeySize is the size in bytes of the random key stored in the header of keyed BLAKE2B signatures
+	Blake2bKeySize uint32 = 32
+
+	// Sha256ChecksumMaxSize is the max size in bytes of SHA-256 strong checksum
+	Sha256ChecksumMaxSize uint32 = 32
+
 	// RollingChecksumCharOffset is a prime number to improve the checksum algorithm
 	RollingChecksumCharOffset uint16 = 31
 
@@ -30,6 +36,25 @@ const (
 	// It's equal to; (RabinkarpMultiplier - 1) * RabinkarpSeed
 	RabinkarpAdjustment uint32 = 0x08104224
 
+	// Rabinkarp64Seed is the seed of RabinkarpChecksum64, see RabinkarpSeed.
+	Rabinkarp64Seed uint64 = 1
+
+	// Rabinkarp64Multiplier is the multiplier of RabinkarpChecksum64, the LCG multiplier of Knuth's MMIX.
+	Rabinkarp64Multiplier uint64 = 0x5851f42d4c957f2d
+
+	// Rabinkarp64MultiplierInverseModular is the inverse of Rabinkarp64Multiplier modular 2^64.
+	Rabinkarp64MultiplierInverseModular uint64 = 0xc097ef87329e28a5
+
+	// Rabinkarp64Adjustment is equal to (Rabinkarp64Multiplier - 1) * Rabinkarp64Seed.
+	Rabinkarp64Adjustment uint64 = 0x5851f42d4c957f2c
+
+	// BuzhashSeed seeds the splitmix64 generator of the Buzhash byte table. The table is part of the signature
+	// format and must never change.
+	BuzhashSeed uint64 = 0x72730166
+
+	// DefaultMaxLiteralSize is a max literal size for WriteDelta callers without a reason to pick another.
+	DefaultMaxLiteralSize uint32 = 32768
+
 	// MinParameterizedLiteralCommand is the minimum literal command code with dynamic size set as parameter.
 	MinParameterizedLiteralCommand byte = 65
 
@@ -49,6 +74,26 @@ const (
 	Rabinkarp_Blake2b ChecksumType = 0x72730147
 )
 
+// The checksum types below are specific to this package, librsync does not read their signatures. Their magics
+// start with 0x676f ("go") instead of the 0x7273 ("rs") of librsync magics, so that they cannot clash with a type
+// librsync adds later.
+const (
+	Rollsum_Sha256   ChecksumType = 0x676f0138
+	Rabinkarp_Sha256 ChecksumType = 0x676f0148
+
+	// Rollsum_Blake2b_Keyed and Rabinkarp_Blake2b_Keyed key the BLAKE2B strong checksum with a random key stored in
+	// the signature header, so that blocks colliding with the signature cannot be crafted in advance.
+	Rollsum_Blake2b_Keyed   ChecksumType = 0x676f0139
+	Rabinkarp_Blake2b_Keyed ChecksumType = 0x676f0149
+
+	// Rabinkarp64_Blake2b uses RabinkarpChecksum64, its signature stores 8-byte weak checksums.
+	Rabinkarp64_Blake2b ChecksumType = 0x676f0157
+
+	// Buzhash_Md4 and Buzhash_Blake2b use BuzhashChecksum, their signatures store 8-byte weak checksums.
+	Buzhash_Md4     ChecksumType = 0x676f0166
+	Buzhash_Blake2b ChecksumType = 0x676f0167
+)
+
 type CommandType byte
 
 const (
@@ -0,0 +1,90 @@
+//go:build interop
+
+package rdiff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLibrsyncCorpus checks the signatures and deltas librsync made for the cases in testdata/librsync, see
+// testdata/librsync/generate.sh. Every case holds the basis and new files, basis.sig and new.delta. The test needs
+// the generated corpus and only runs with the interop build tag:
+//
+//	go test -tags interop -run TestLibrsyncCorpus
+func TestLibrsyncCorpus(t *testing.T) {
+	cases, err := filepath.Glob(filepath.Join("testdata", "librsync", "*", "basis.sig"))
+	assert.Nil(t, err)
+	if len(cases) == 0 {
+		t.Fatal("no librsync corpus, run testdata/librsync/generate.sh")
+	}
+
+	for _, signatureFile := range cases {
+		dir := filepath.Dir(signatureFile)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			basis, err := os.ReadFile(filepath.Join(dir, "basis"))
+			assert.Nil(t, err)
+			newFile, err := os.ReadFile(filepath.Join(dir, "new"))
+			assert.Nil(t, err)
+			expectedSignature, err := os.ReadFile(signatureFile)
+			assert.Nil(t, err)
+			expectedDelta, err := os.ReadFile(filepath.Join(dir, "new.delta"))
+			assert.Nil(t, err)
+
+			signature, err := ReadSignature(bytes.NewReader(expectedSignature))
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// Same signature as librsync with the same parameters
+			actualSignature := &bytes.Buffer{}
+			err = WriteSignature(bytes.NewReader(basis), actualSignature, signature.checksumType, signature.blockSize, signature.strongChecksumSize)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedSignature, actualSignature.Bytes())
+
+			// The librsync delta patches the basis into the new file
+			actualNewFile := &bytes.Buffer{}
+			err = Patch(bytes.NewReader(basis), actualNewFile, bytes.NewReader(expectedDelta))
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, actualNewFile.Bytes())
+
+			// Same delta as librsync
+			actualDelta := &bytes.Buffer{}
+			err = WriteDelta(signature, bytes.NewReader(newFile), actualDelta, DefaultMaxLiteralSize)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedDelta, actualDelta.Bytes())
+		})
+	}
+}
+
+// TestVCDIFFCorpus checks that PatchVCDIFF decodes the deltas xdelta3 and open-vcdiff made for the cases in
+// testdata/vcdiff, see testdata/vcdiff/generate.sh. Every case holds the source and target files and target.vcdiff.
+// Like TestLibrsyncCorpus, the test needs the generated corpus and only runs with the interop build tag.
+func TestVCDIFFCorpus(t *testing.T) {
+	cases, err := filepath.Glob(filepath.Join("testdata", "vcdiff", "*", "target.vcdiff"))
+	assert.Nil(t, err)
+	if len(cases) == 0 {
+		t.Fatal("no VCDIFF corpus, run testdata/vcdiff/generate.sh")
+	}
+
+	for _, deltaFile := range cases {
+		dir := filepath.Dir(deltaFile)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			source, err := os.ReadFile(filepath.Join(dir, "source"))
+			assert.Nil(t, err)
+			target, err := os.ReadFile(filepath.Join(dir, "target"))
+			assert.Nil(t, err)
+			delta, err := os.ReadFile(deltaFile)
+			assert.Nil(t, err)
+
+			actualTarget := &bytes.Buffer{}
+			err = PatchVCDIFF(bytes.NewReader(source), actualTarget, bytes.NewReader(delta))
+			assert.Nil(t, err)
+			assert.Equal(t, target, actualTarget.Bytes())
+		})
+	}
+}
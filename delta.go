@@ -2,206 +2,281 @@ package rdiff
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"github.com/balena-os/circbuf"
 	"io"
 )
 
-func getByteSize(value uint64) byte {
-	if (value >> 32) > 0 {
-		return 8
-	} else if (value >> 16) > 0 {
-		return 4
-	} else if (value >> 8) > 0 {
-		return 2
-	} else {
-		return 1
+// deltaEncoder receives the commands found by scanDelta.
+type deltaEncoder interface {
+	literal(data []byte) error
+	copy(position, length uint64) error
+	end() error
+}
+
+// commandEncoder writes the commands into a delta file, literal data are buffered up to maxLiteralSize bytes.
+type commandEncoder struct {
+	out            io.Writer
+	literalCommand *Command
+}
+
+func (w *commandEncoder) literal(data []byte) error {
+	for len(data) > 0 {
+		if len(w.literalCommand.literalData) >= int(w.literalCommand.maxLiteralSize) {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+
+		size := int(w.literalCommand.maxLiteralSize) - len(w.literalCommand.literalData)
+		if size > len(data) {
+			size = len(data)
+		}
+		w.literalCommand.literalData = append(w.literalCommand.literalData, data[:size]...)
+		w.literalCommand.length += uint64(size)
+		data = data[size:]
 	}
+
+	return nil
 }
 
-func getCommandOffset(size byte) byte {
-	switch size {
-	case 1:
-		return 0
-	case 2:
-		return 1
-	case 4:
-		return 2
-	default:
-		return 3
+func (w *commandEncoder) copy(position, length uint64) error {
+	if err := w.flush(); err != nil {
+		return err
 	}
+
+	return writeCommand(w.out, &Command{commandType: Copy, position: position, length: length})
 }
 
-func writeParam(out io.Writer, value uint64, size uint8) error {
-	switch size {
-	case 1:
-		return binary.Write(out, binary.BigEndian, uint8(value))
-	case 2:
-		return binary.Write(out, binary.BigEndian, uint16(value))
-	case 4:
-		return binary.Write(out, binary.BigEndian, uint32(value))
-	case 8:
-		return binary.Write(out, binary.BigEndian, value)
+func (w *commandEncoder) end() error {
+	if err := w.flush(); err != nil {
+		return err
 	}
 
-	return fmt.Errorf("invalid data size: %v", size)
+	return writeCommand(w.out, &Command{commandType: End})
 }
 
-type Command struct {
-	commandType    CommandType
-	position       uint64
-	length         uint64
-	literalData    []byte
-	maxLiteralSize uint32
+func (w *commandEncoder) flush() error {
+	if w.literalCommand.length == 0 {
+		return nil
+	}
+
+	return writeCommand(w.out, w.literalCommand)
 }
 
-func writeCommand(out io.Writer, command *Command) error {
-	if command.commandType == Literal {
-		if command.length == 0 {
-			return fmt.Errorf("empty literal")
-		} else if command.length < uint64(MinParameterizedLiteralCommand) {
-			if _, err := out.Write([]byte{byte(command.length)}); err != nil {
-				return err
-			}
-		} else {
-			byteSize := getByteSize(command.length)
-			offset := getCommandOffset(byteSize)
-			commandCode := MinParameterizedLiteralCommand + offset
-			if _, err := out.Write([]byte{commandCode}); err != nil {
-				return err
-			}
+// DeltaStats describes the delta WriteDelta produces.
+type DeltaStats struct {
+	LiteralCommands uint64
+	CopyCommands    uint64
 
-			if err := writeParam(out, command.length, byteSize); err != nil {
-				return err
-			}
-		}
-		if _, err := out.Write(command.literalData); err != nil {
-			return err
-		}
-		command.literalData = make([]byte, 0, command.maxLiteralSize)
-	} else if command.commandType == Copy {
-		positionByteSize := getByteSize(command.position)
-		lengthByteSize := getByteSize(command.length)
-		positionOffset := getCommandOffset(positionByteSize)
-		lengthOffset := getCommandOffset(lengthByteSize)
-
-		commandCode := MinCopyCommand + positionOffset*4 + lengthOffset
-		if _, err := out.Write([]byte{commandCode}); err != nil {
-			return err
-		}
+	// LiteralBytes is the number of bytes of the new file written as literal data.
+	LiteralBytes uint64
 
-		if err := writeParam(out, command.position, positionByteSize); err != nil {
-			return err
-		}
+	// CopyBytes is the number of bytes of the new file copied from the original file.
+	CopyBytes uint64
 
-		if err := writeParam(out, command.length, lengthByteSize); err != nil {
-			return err
+	// EncodedSize is the size of the delta file including the magic number and the end command.
+	EncodedSize uint64
+}
+
+// deltaCounter counts the commands into DeltaStats without keeping literal data.
+type deltaCounter struct {
+	stats          *DeltaStats
+	literalLength  uint64
+	maxLiteralSize uint64
+}
+
+func (c *deltaCounter) literal(data []byte) error {
+	length := uint64(len(data))
+	for length > 0 {
+		if c.literalLength >= c.maxLiteralSize {
+			c.flush()
 		}
-	} else if command.commandType == End {
-		if _, err := out.Write([]byte{0}); err != nil {
-			return err
+
+		size := c.maxLiteralSize - c.literalLength
+		if size > length {
+			size = length
 		}
+		c.literalLength += size
+		length -= size
 	}
 
 	return nil
 }
 
-func WriteDelta(signature *Signature, in io.Reader, out io.Writer, maxLiteralSize uint32) error {
-	if err := binary.Write(out, binary.BigEndian, DeltaMagicNumber); err != nil {
-		return err
+func (c *deltaCounter) copy(position, length uint64) error {
+	c.flush()
+	c.stats.CopyCommands++
+	c.stats.CopyBytes += length
+	c.stats.EncodedSize += commandHeaderSize(Copy, position, length)
+
+	return nil
+}
+
+func (c *deltaCounter) end() error {
+	c.flush()
+	c.stats.EncodedSize += commandHeaderSize(End, 0, 0)
+
+	return nil
+}
+
+func (c *deltaCounter) flush() {
+	if c.literalLength == 0 {
+		return
 	}
 
+	c.stats.LiteralCommands++
+	c.stats.LiteralBytes += c.literalLength
+	c.stats.EncodedSize += commandHeaderSize(Literal, 0, c.literalLength) + c.literalLength
+	c.literalLength = 0
+}
+
+// scanDelta finds the blocks of signature in the input and passes the resulting commands to encoder.
+func scanDelta(signature *Signature, in io.Reader, encoder deltaEncoder) error {
 	blockSize := uint64(signature.blockSize)
 	block, err := circbuf.NewBuffer(int64(blockSize))
 	if err != nil {
 		return err
 	}
 
-	firstByte := byte(0)
-	position := ^uint64(0)
-	checksum, err := NewChecksum(signature.checksumType)
-	input := bufio.NewReaderSize(in, int(blockSize))
-	literalCommand := &Command{commandType: Literal, literalData: make([]byte, 0, maxLiteralSize), maxLiteralSize: maxLiteralSize}
-	for {
-		position++
-		nextByte, err := input.ReadByte()
-		if errors.Is(err, io.EOF) {
-			break
-		} else if err != nil {
-			return err
-		}
+	checksum, err := NewKeyedChecksum(signature.checksumType, signature.key)
+	if err != nil {
+		return err
+	}
 
-		if block.TotalWritten() > 0 {
-			if firstByte, err = block.Get(0); err != nil {
-				return err
-			}
-		}
+	s := &deltaScanner{
+		signature: signature,
+		input:     bufio.NewReaderSize(in, int(blockSize)),
+		encoder:   encoder,
+		checksum:  checksum,
+		block:     block,
+		blockSize: blockSize,
+		firstByte: make([]byte, 1),
+	}
 
-		checksum.Rollin(nextByte)
-		if err = block.WriteByte(nextByte); err != nil {
-			return err
-		}
+	if err = s.scan(checksum.rollingHash); err != nil {
+		return err
+	}
 
-		if checksum.Count() < blockSize {
-			continue
-		} else if checksum.Count() > blockSize {
-			firstBytePosition := position - blockSize
-			if (len(literalCommand.literalData) >= int(maxLiteralSize)) || (literalCommand.length > 0 && literalCommand.position+literalCommand.length != firstBytePosition) {
-				if err := writeCommand(out, literalCommand); err != nil {
-					return err
-				}
-			}
+	if err = encoder.literal(block.Bytes()); err != nil {
+		return err
+	}
 
-			literalCommand.literalData = append(literalCommand.literalData, firstByte)
-			if literalCommand.length == 0 {
-				literalCommand.position = firstBytePosition
-			}
-			literalCommand.length++
+	return encoder.end()
+}
+
+// deltaScanner holds the state of scanDelta around the rolling hash.
+type deltaScanner struct {
+	signature *Signature
+	input     *bufio.Reader
+	encoder   deltaEncoder
+	checksum  *Checksum
+	block     circbuf.Buffer
+	blockSize uint64
 
-			checksum.Rollout(firstByte)
+	// firstByte holds the byte leaving the window, it is passed to the encoder without an allocation per byte.
+	firstByte []byte
+}
+
+// next reads the next input byte and adds it to the window. When the window is full, the byte leaving it is passed
+// to the encoder as a literal and returned with full set. At the end of the input next returns io.EOF.
+func (s *deltaScanner) next(count uint64) (in, out byte, full bool, err error) {
+	if in, err = s.input.ReadByte(); err != nil {
+		return 0, 0, false, err
+	}
+
+	if full = count == s.blockSize; full {
+		if s.firstByte[0], err = s.block.Get(0); err != nil {
+			return 0, 0, false, err
+		}
+		if err = s.encoder.literal(s.firstByte); err != nil {
+			return 0, 0, false, err
 		}
+	}
 
-		if blockIndex, ok := signature.weakChecksums[checksum.Digest()]; ok {
-			strongChecksum, err := checksum.CalculateStrongChecksum(block.Bytes(), signature.strongChecksumSize)
-			if err != nil {
-				return err
-			}
+	return in, s.firstByte[0], full, s.block.WriteByte(in)
+}
 
-			if bytes.Equal(strongChecksum, signature.strongChecksums[blockIndex]) {
-				if len(literalCommand.literalData) > 0 {
-					if err = writeCommand(out, literalCommand); err != nil {
-						return err
-					}
-				}
+// match passes a copy to the encoder and empties the window when the full window matches a block. It reports
+// whether the rolling hash must be reset.
+func (s *deltaScanner) match(count, weakChecksum uint64) (bool, error) {
+	// The window is only copied out of the circular buffer for weak checksums found in the signature.
+	if _, ok := s.signature.weakChecksums[weakChecksum]; !ok || count < s.blockSize {
+		return false, nil
+	}
 
-				if err = writeCommand(out, &Command{commandType: Copy, position: uint64(blockIndex) * blockSize, length: blockSize}); err != nil {
-					return err
-				}
+	blockIndex, err := s.signature.findBlock(s.checksum, weakChecksum, s.block.Bytes())
+	if err != nil || blockIndex < 0 {
+		return false, err
+	}
 
-				block.Reset()
-				checksum.Reset()
-			}
-		}
+	if err = s.encoder.copy(uint64(blockIndex)*s.blockSize, s.blockSize); err != nil {
+		return false, err
 	}
+	s.block.Reset()
+
+	return true, nil
+}
 
-	remainingBytes := append(literalCommand.literalData, block.Bytes()...)
-	remainingBytesLen := len(remainingBytes)
-	for begin := 0; begin < remainingBytesLen; begin += int(maxLiteralSize) {
-		end := begin + int(maxLiteralSize)
-		if end > remainingBytesLen {
-			end = remainingBytesLen
+// endOfInput returns nil for io.EOF, which ends the scan of full windows.
+func endOfInput(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+// scan moves the window over the input one byte at a time until the input ends.
+func (s *deltaScanner) scan(h RollingHash) error {
+	for {
+		in, out, full, err := s.next(h.Count())
+		if err != nil {
+			return endOfInput(err)
 		}
-		literalCommand.literalData = remainingBytes[begin:end]
-		literalCommand.length = uint64(len(literalCommand.literalData))
-		if literalCommand.length > 0 {
-			if err = writeCommand(out, literalCommand); err != nil {
-				return err
-			}
+
+		if full {
+			h.Rotate(out, in)
+		} else {
+			h.Rollin(in)
 		}
+
+		if matched, err := s.match(h.Count(), h.Digest()); err != nil {
+			return err
+		} else if matched {
+			h.Reset()
+		}
+	}
+}
+
+// WriteDelta writes the delta from the original file described by signature to in. Literal commands hold at most
+// maxLiteralSize bytes.
+func WriteDelta(signature *Signature, in io.Reader, out io.Writer, maxLiteralSize uint32) error {
+	if maxLiteralSize == 0 {
+		return ErrInvalidMaxLiteralSize
+	}
+
+	if err := binary.Write(out, binary.BigEndian, DeltaMagicNumber); err != nil {
+		return err
+	}
+
+	literalCommand := &Command{commandType: Literal, literalData: make([]byte, 0, maxLiteralSize), maxLiteralSize: maxLiteralSize}
+
+	return scanDelta(signature, in, &commandEncoder{out: out, literalCommand: literalCommand})
+}
+
+// EstimateDelta runs the delta generation of WriteDelta on the input without writing or keeping any literal data
+// and returns the statistics of the delta WriteDelta would write.
+func EstimateDelta(signature *Signature, in io.Reader, maxLiteralSize uint32) (*DeltaStats, error) {
+	if maxLiteralSize == 0 {
+		return nil, ErrInvalidMaxLiteralSize
+	}
+
+	stats := &DeltaStats{EncodedSize: 4}
+	if err := scanDelta(signature, in, &deltaCounter{stats: stats, maxLiteralSize: uint64(maxLiteralSize)}); err != nil {
+		return nil, err
 	}
 
-	return writeCommand(out, &Command{commandType: End})
+	return stats, nil
 }
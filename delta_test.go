@@ -3,6 +3,8 @@ package rdiff
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"testing"
 
 	cryptoRand "crypto/rand"
@@ -12,7 +14,7 @@ import (
 func TestWriteDelta_NoChange(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -49,7 +51,7 @@ func TestWriteDelta_NoChange(t *testing.T) {
 func TestWriteDelta_PrependFile(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -96,7 +98,7 @@ func TestWriteDelta_PrependFile(t *testing.T) {
 func TestWriteDelta_AppendFile(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -139,7 +141,7 @@ func TestWriteDelta_AppendFile(t *testing.T) {
 func TestWriteDelta_InsertBetweenBlocks(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -194,7 +196,7 @@ func TestWriteDelta_InsertBetweenBlocks(t *testing.T) {
 func TestWriteDelta_ModifyBlock(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -241,7 +243,7 @@ func TestWriteDelta_ModifyBlock(t *testing.T) {
 func TestWriteDelta_RemoveBlock(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -285,7 +287,7 @@ func TestWriteDelta_RemoveBlock(t *testing.T) {
 func TestWriteDelta_SmallMaxLiteralSize(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -322,3 +324,204 @@ func TestWriteDelta_SmallMaxLiteralSize(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteDelta_DuplicateBlocks(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate a block that is repeated in the original file
+		blockSize := rand64(100, 1000)
+		block, err := generateBytes(blockSize)
+		assert.Nil(t, err)
+		originalFile := bytes.Repeat(block, 3)
+
+		// The copy reads the first of the blocks with the same checksums
+		expectedDelta := &bytes.Buffer{}
+		err = binary.Write(expectedDelta, binary.BigEndian, DeltaMagicNumber)
+		assert.Nil(t, err)
+		err = writeCommand(expectedDelta, &Command{commandType: Copy, position: 0, length: blockSize})
+		assert.Nil(t, err)
+		expectedDelta.WriteByte(0)
+
+		actualDelta, err := generateDelta(originalFile, block, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		assert.Equal(t, expectedDelta, actualDelta)
+	}
+}
+
+func TestWriteDelta_ManyDuplicateBlocks(t *testing.T) {
+	// Every block of the original file has the same checksums, each copy reads the first block
+	const blockSize = 1024
+	originalFile := make([]byte, 4<<20)
+
+	delta, err := generateDelta(originalFile, originalFile, blockSize, Rollsum_Md4, 8, blockSize*2)
+	assert.Nil(t, err)
+
+	reader, err := NewDeltaReader(delta)
+	assert.Nil(t, err)
+	for i := 0; i < len(originalFile)/blockSize; i++ {
+		command, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, Copy, command.Type)
+		assert.Equal(t, uint64(0), command.Position)
+	}
+	command, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, End, command.Type)
+}
+
+func TestWriteDelta_WeakChecksumCollision(t *testing.T) {
+	blockSize := rand64(100, 1000)
+	block, err := generateBytes(blockSize)
+	assert.Nil(t, err)
+	block[0], block[1], block[2] = 100, 100, 100
+
+	// Adding 1, -2 and 1 to 3 consecutive bytes keeps both sums of the rollsum
+	collision := append([]byte{}, block...)
+	collision[0], collision[1], collision[2] = 101, 98, 101
+	originalFile := append(collision, block...)
+
+	checksum, err := NewChecksum(Rollsum_Blake2b)
+	assert.Nil(t, err)
+	assert.Equal(t, checksum.CalculateWeakChecksum(block), checksum.CalculateWeakChecksum(collision))
+
+	// The block is found behind the first block with the same weak checksum
+	expectedDelta := &bytes.Buffer{}
+	err = binary.Write(expectedDelta, binary.BigEndian, DeltaMagicNumber)
+	assert.Nil(t, err)
+	err = writeCommand(expectedDelta, &Command{commandType: Copy, position: blockSize, length: blockSize})
+	assert.Nil(t, err)
+	expectedDelta.WriteByte(0)
+
+	actualDelta, err := generateDelta(originalFile, block, uint32(blockSize), Rollsum_Blake2b, 32, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	assert.Equal(t, expectedDelta, actualDelta)
+}
+
+func TestEstimateDelta(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate original file
+		blockNumber, blockSize, _, originalFile, err := generateFile(3, 100)
+		assert.Nil(t, err)
+
+		// Modify one of the blocks and append random bytes at the end of the file
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile...)
+		blockIndex := rand64(0, int(blockNumber)-2)
+		_, err = cryptoRand.Read(newFile[blockIndex*blockSize : (blockIndex+1)*blockSize])
+		assert.Nil(t, err)
+		insertData, err := generateBytes(rand64(1, 1000))
+		assert.Nil(t, err)
+		newFile = append(newFile, insertData...)
+
+		signatureBuffer := &bytes.Buffer{}
+		err = WriteSignature(bytes.NewReader(originalFile), signatureBuffer, checksumType, uint32(blockSize), 8)
+		assert.Nil(t, err)
+		signature, err := ReadSignature(signatureBuffer)
+		assert.Nil(t, err)
+
+		for _, maxLiteralSize := range []uint32{uint32(blockSize / 3), uint32(blockSize * 2)} {
+			// Calculate actual delta and its statistics
+			delta := &bytes.Buffer{}
+			err = WriteDelta(signature, bytes.NewReader(newFile), delta, maxLiteralSize)
+			assert.Nil(t, err)
+
+			expectedStats := &DeltaStats{EncodedSize: uint64(delta.Len())}
+			reader, err := NewDeltaReader(delta)
+			assert.Nil(t, err)
+			for command, err := reader.Next(); err == nil; command, err = reader.Next() {
+				if command.Type == Literal {
+					expectedStats.LiteralCommands++
+					expectedStats.LiteralBytes += command.Length
+				} else if command.Type == Copy {
+					expectedStats.CopyCommands++
+					expectedStats.CopyBytes += command.Length
+				}
+			}
+
+			// Estimate delta
+			actualStats, err := EstimateDelta(signature, bytes.NewReader(newFile), maxLiteralSize)
+			assert.Nil(t, err)
+
+			assert.Equal(t, expectedStats, actualStats)
+		}
+	}
+}
+
+func BenchmarkWriteDelta(b *testing.B) {
+	const blockSize = 2048
+
+	originalFile, err := generateBytes(8 << 20)
+	assert.Nil(b, err)
+	newFile := generateSparseChanges(originalFile, blockSize*4)
+
+	for _, checksumType := range ChecksumTypes {
+		b.Run(fmt.Sprintf("%#x", checksumType), func(b *testing.B) {
+			signatureBuffer := &bytes.Buffer{}
+			assert.Nil(b, WriteSignature(bytes.NewReader(originalFile), signatureBuffer, checksumType, blockSize, 16))
+			signature, err := ReadSignature(signatureBuffer)
+			assert.Nil(b, err)
+
+			b.SetBytes(int64(len(newFile)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				if err = WriteDelta(signature, bytes.NewReader(newFile), io.Discard, blockSize*4); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWriteDelta_DuplicateBlocks measures a file whose blocks all have the same checksums.
+func BenchmarkWriteDelta_DuplicateBlocks(b *testing.B) {
+	const blockSize = 1024
+
+	originalFile := make([]byte, 16<<20)
+	signatureBuffer := &bytes.Buffer{}
+	assert.Nil(b, WriteSignature(bytes.NewReader(originalFile), signatureBuffer, Rollsum_Md4, blockSize, 8))
+	signature, err := ReadSignature(signatureBuffer)
+	assert.Nil(b, err)
+
+	b.SetBytes(int64(len(originalFile)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if err = WriteDelta(signature, bytes.NewReader(originalFile), io.Discard, blockSize*4); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteDelta_NoMatch measures the rolling hash scan alone: no block of the original file is found in the
+// new file, so the weak checksum is looked up once per byte and no strong checksum is calculated.
+func BenchmarkWriteDelta_NoMatch(b *testing.B) {
+	const blockSize = 2048
+
+	originalFile, err := generateBytes(blockSize * 16)
+	assert.Nil(b, err)
+	newFile, err := generateBytes(8 << 20)
+	assert.Nil(b, err)
+
+	for _, checksumType := range ChecksumTypes {
+		b.Run(fmt.Sprintf("%#x", checksumType), func(b *testing.B) {
+			signatureBuffer := &bytes.Buffer{}
+			assert.Nil(b, WriteSignature(bytes.NewReader(originalFile), signatureBuffer, checksumType, blockSize, 16))
+			signature, err := ReadSignature(signatureBuffer)
+			assert.Nil(b, err)
+
+			b.SetBytes(int64(len(newFile)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				if err = WriteDelta(signature, bytes.NewReader(newFile), io.Discard, blockSize*4); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
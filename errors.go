@@ -0,0 +1,178 @@
+package rdiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrBadMagic is reported when a delta file does not start with DeltaMagicNumber.
+	ErrBadMagic = errors.New("bad magic number")
+
+	// ErrUnknownCommand is reported for a reserved command code.
+	ErrUnknownCommand = errors.New("unknown command code")
+
+	// ErrTruncatedDelta is reported when a delta file ends before its end command.
+	ErrTruncatedDelta = errors.New("truncated delta")
+
+	// ErrTrailingData is reported when a delta file has data after the end command.
+	ErrTrailingData = errors.New("trailing data after end command")
+
+	// ErrParameterOverflow is reported when a command position or length, or their sum, does not fit in an int64.
+	ErrParameterOverflow = errors.New("command parameter overflows int64")
+
+	// ErrCopyOutOfRange is reported when a copy command reads past the end of the original file.
+	ErrCopyOutOfRange = errors.New("copy command out of original file range")
+
+	// ErrEmptyLiteral is reported when writing a literal command without data.
+	ErrEmptyLiteral = errors.New("empty literal")
+
+	// ErrInvalidChecksumType is reported for an unknown signature checksum type.
+	ErrInvalidChecksumType = errors.New("invalid checksum type")
+
+	// ErrInvalidStrongChecksumSize is reported when a strong checksum size exceeds the size the checksum type allows.
+	ErrInvalidStrongChecksumSize = errors.New("invalid strong checksum size")
+
+	// ErrInvalidChecksumKey is reported when the key of a keyed checksum type is missing or has the wrong size.
+	ErrInvalidChecksumKey = errors.New("invalid checksum key")
+
+	// ErrTruncatedSignature is reported when a signature file ends in the middle of its header or of a block.
+	ErrTruncatedSignature = errors.New("truncated signature")
+
+	// ErrInvalidMaxLiteralSize is reported when the max literal size of WriteDelta or PatchWithReverseDelta is 0.
+	ErrInvalidMaxLiteralSize = errors.New("max literal size must be positive")
+
+	// ErrStaleOriginal is matched by every *StaleBlockError.
+	ErrStaleOriginal = errors.New("original file does not match signature")
+
+	// ErrLimitExceeded is matched by every *LimitError.
+	ErrLimitExceeded = errors.New("patch limit exceeded")
+
+	// ErrCheckpointMismatch is reported by ResumePatch when the checkpoint was taken with another original file or
+	// delta.
+	ErrCheckpointMismatch = errors.New("checkpoint does not match original file or delta")
+
+	// ErrInvalidVCDIFF is reported when a VCDIFF delta breaks RFC 3284.
+	ErrInvalidVCDIFF = errors.New("invalid VCDIFF delta")
+
+	// ErrUnsupportedVCDIFF is reported for VCDIFF secondary compression, application-defined code tables, windows
+	// copying from the target file and target windows larger than 64 MiB.
+	ErrUnsupportedVCDIFF = errors.New("unsupported VCDIFF feature")
+
+	// ErrVCDIFFChecksum is reported when a VCDIFF target window does not match its Adler-32 checksum.
+	ErrVCDIFFChecksum = errors.New("VCDIFF window checksum mismatch")
+)
+
+// DeltaError reports the command of a delta file where a problem was found. Command is -1 for problems in the
+// magic number.
+type DeltaError struct {
+	// Offset is the position of the command in the delta file.
+	Offset int64
+
+	// Command is the index of the command, the first command after the magic number has index 0.
+	Command int64
+
+	Err error
+}
+
+func (e *DeltaError) Error() string {
+	if e.Command < 0 {
+		return fmt.Sprintf("delta magic number: %v", e.Err)
+	}
+
+	return fmt.Sprintf("delta command %d at offset %d: %v", e.Command, e.Offset, e.Err)
+}
+
+func (e *DeltaError) Unwrap() error {
+	return e.Err
+}
+
+// SignatureError reports the block of a signature file where a problem was found. Block is -1 for problems in the
+// header.
+type SignatureError struct {
+	// Offset is the position of the header or block in the signature file.
+	Offset int64
+
+	// Block is the index of the block, the first block after the header has index 0.
+	Block int64
+
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	if e.Block < 0 {
+		return fmt.Sprintf("signature header: %v", e.Err)
+	}
+
+	return fmt.Sprintf("signature block %d at offset %d: %v", e.Block, e.Offset, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// LimitError is reported when a delta exceeds one of the limits of PatchOptions.
+type LimitError struct {
+	// Limit is the name of the PatchOptions field that was exceeded.
+	Limit string
+	Value uint64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %d exceeds %s %d", ErrLimitExceeded, e.Value, e.Limit, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// StaleBlockError is reported when a block of the original file no longer matches the signature the delta was made
+// from, see PatchOptions.Signature.
+type StaleBlockError struct {
+	// Block is the index of the first block found changed, Offset is its position in the original file.
+	Block  int64
+	Offset int64
+}
+
+func (e *StaleBlockError) Error() string {
+	return fmt.Sprintf("%s: block %d at offset %d", ErrStaleOriginal, e.Block, e.Offset)
+}
+
+func (e *StaleBlockError) Is(target error) bool {
+	return target == ErrStaleOriginal
+}
+
+// deltaError wraps err into a *DeltaError unless it already is one. A premature end of the delta becomes
+// ErrTruncatedDelta.
+func deltaError(err error, offset, command int64) error {
+	var e *DeltaError
+	if errors.As(err, &e) {
+		return err
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncatedDelta
+	}
+
+	return &DeltaError{Offset: offset, Command: command, Err: err}
+}
+
+// copyError turns a premature end of the original file while copying into ErrCopyOutOfRange of the command.
+func copyError(err error, offset, command int64) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &DeltaError{Offset: offset, Command: command, Err: ErrCopyOutOfRange}
+	}
+
+	return err
+}
+
+// signatureError wraps err into a *SignatureError. A premature end of the signature becomes ErrTruncatedSignature.
+func signatureError(err error, offset, block int64) error {
+	if err == io.ErrUnexpectedEOF || (err == io.EOF && block < 0) {
+		err = ErrTruncatedSignature
+	}
+
+	return &SignatureError{Offset: offset, Block: block, Err: err}
+}
@@ -0,0 +1,110 @@
+package rdiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors_Signature(t *testing.T) {
+	// Invalid checksum type
+	err := WriteSignature(bytes.NewReader([]byte{1, 2, 3}), &bytes.Buffer{}, ChecksumType(0x12345678), 100, 8)
+	assert.True(t, errors.Is(err, ErrInvalidChecksumType))
+
+	_, err = ReadSignature(bytes.NewReader([]byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 100, 0, 0, 0, 8}))
+	var signatureError *SignatureError
+	assert.True(t, errors.As(err, &signatureError))
+	assert.Equal(t, int64(-1), signatureError.Block)
+	assert.True(t, errors.Is(err, ErrInvalidChecksumType))
+
+	// Invalid strong checksum size
+	err = WriteSignature(bytes.NewReader([]byte{1, 2, 3}), &bytes.Buffer{}, Rollsum_Md4, 100, 32)
+	assert.True(t, errors.Is(err, ErrInvalidStrongChecksumSize))
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header, uint32(Rollsum_Md4))
+	binary.BigEndian.PutUint32(header[4:], 100)
+	binary.BigEndian.PutUint32(header[8:], 32)
+	_, err = ReadSignature(bytes.NewReader(header))
+	assert.True(t, errors.Is(err, ErrInvalidStrongChecksumSize))
+
+	// Truncated header
+	_, err = ReadSignature(bytes.NewReader(header[:6]))
+	assert.True(t, errors.As(err, &signatureError))
+	assert.Equal(t, int64(-1), signatureError.Block)
+	assert.True(t, errors.Is(err, ErrTruncatedSignature))
+
+	// Truncated block
+	signature := &bytes.Buffer{}
+	assert.Nil(t, WriteSignature(bytes.NewReader(make([]byte, 250)), signature, Rollsum_Md4, 100, 8))
+	for _, size := range []int{signature.Len() - 1, signature.Len() - 8, signature.Len() - 10} {
+		_, err = ReadSignature(bytes.NewReader(signature.Bytes()[:size]))
+		assert.True(t, errors.As(err, &signatureError))
+		assert.Equal(t, int64(2), signatureError.Block)
+		assert.Equal(t, int64(12+2*12), signatureError.Offset)
+		assert.True(t, errors.Is(err, ErrTruncatedSignature))
+	}
+}
+
+func TestErrors_Delta(t *testing.T) {
+	// Bad magic number
+	err := Patch(bytes.NewReader(nil), &bytes.Buffer{}, bytes.NewReader([]byte{0x72, 0x73, 0x01, 0x36, 0}))
+	var deltaError *DeltaError
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(-1), deltaError.Command)
+	assert.True(t, errors.Is(err, ErrBadMagic))
+
+	// Truncated magic number
+	_, err = NewDeltaReader(bytes.NewReader([]byte{0x72, 0x73}))
+	assert.True(t, errors.Is(err, ErrTruncatedDelta))
+
+	// Unknown command code
+	err = Patch(bytes.NewReader(nil), &bytes.Buffer{}, bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, MinReservedCommand}))
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(6), deltaError.Offset)
+	assert.Equal(t, int64(1), deltaError.Command)
+	assert.True(t, errors.Is(err, ErrUnknownCommand))
+
+	// Truncated literal data
+	err = Patch(bytes.NewReader(nil), &bytes.Buffer{}, bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, 3, 0xaa}))
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(6), deltaError.Offset)
+	assert.Equal(t, int64(1), deltaError.Command)
+	assert.True(t, errors.Is(err, ErrTruncatedDelta))
+
+	// Empty literal
+	assert.True(t, errors.Is(writeCommand(&bytes.Buffer{}, &Command{commandType: Literal}), ErrEmptyLiteral))
+
+	// Zero max literal size
+	err = WriteDelta(&Signature{blockSize: 100, checksumType: Rollsum_Md4}, bytes.NewReader(nil), &bytes.Buffer{}, 0)
+	assert.True(t, errors.Is(err, ErrInvalidMaxLiteralSize))
+}
+
+func TestErrors_Limit(t *testing.T) {
+	err := &LimitError{Limit: "MaxOutputSize", Value: 5000, Max: 1000}
+	assert.Equal(t, "patch limit exceeded: 5000 exceeds MaxOutputSize 1000", err.Error())
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+}
+
+func TestErrors_CopyOutOfRange(t *testing.T) {
+	delta := []byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, MinCopyCommand, 90, 20, 0}
+	originalFile := make([]byte, 100)
+
+	err := Patch(bytes.NewReader(originalFile), &bytes.Buffer{}, bytes.NewReader(delta))
+	var deltaError *DeltaError
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(6), deltaError.Offset)
+	assert.Equal(t, int64(1), deltaError.Command)
+	assert.True(t, errors.Is(err, ErrCopyOutOfRange))
+
+	err = PatchWithOptions(bytes.NewReader(originalFile), &bytes.Buffer{}, bytes.NewReader(delta), &PatchOptions{ReadAhead: 4})
+	assert.True(t, errors.Is(err, ErrCopyOutOfRange))
+
+	err = PatchAt(bytes.NewReader(originalFile), &memoryFile{}, bytes.NewReader(delta), 2)
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(1), deltaError.Command)
+	assert.True(t, errors.Is(err, ErrCopyOutOfRange))
+}
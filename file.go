@@ -0,0 +1,118 @@
+package rdiff
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultBackupSuffix is appended to the output path to name the backup when PatchFileOptions.BackupSuffix is empty.
+const DefaultBackupSuffix = "~"
+
+// PatchFileOptions configures PatchFile.
+type PatchFileOptions struct {
+	// Backup keeps the previous version of the output file next to it.
+	Backup bool
+
+	// BackupSuffix is appended to the output path to name the backup, DefaultBackupSuffix is used when empty.
+	BackupSuffix string
+}
+
+// PatchFile applies delta to the file at originalPath and atomically replaces the file at outputPath with the result.
+// The new file is written to a temporary file in the directory of outputPath, synced and renamed over outputPath,
+// then the directory is synced. The new file keeps the permissions and owner of the replaced file, or of the
+// original file when outputPath does not exist yet. The temporary file is removed on failure. originalPath and
+// outputPath may be the same file.
+func PatchFile(originalPath string, delta io.Reader, outputPath string, options *PatchFileOptions) (err error) {
+	if options == nil {
+		options = &PatchFileOptions{}
+	}
+
+	originalFile, err := os.Open(originalPath)
+	if err != nil {
+		return err
+	}
+	defer originalFile.Close()
+
+	outputExists := true
+	info, err := os.Stat(outputPath)
+	if errors.Is(err, os.ErrNotExist) {
+		outputExists = false
+		info, err = originalFile.Stat()
+	}
+	if err != nil {
+		return err
+	}
+
+	directory, name := filepath.Split(outputPath)
+	tempFile, err := os.CreateTemp(directory, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	output := bufio.NewWriter(tempFile)
+	if err = Patch(originalFile, output, delta); err != nil {
+		return err
+	}
+	if err = output.Flush(); err != nil {
+		return err
+	}
+
+	// The owner is set first since changing it clears the setuid and setgid bits.
+	if err = copyOwner(tempFile, info); err != nil {
+		return err
+	}
+	if err = tempFile.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+		return err
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+
+	if options.Backup && outputExists {
+		suffix := options.BackupSuffix
+		if suffix == "" {
+			suffix = DefaultBackupSuffix
+		}
+
+		backupPath := outputPath + suffix
+		if err = os.Remove(backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err = os.Link(outputPath, backupPath); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(tempFile.Name(), outputPath); err != nil {
+		return err
+	}
+
+	return syncDirectory(directory)
+}
+
+func syncDirectory(path string) error {
+	if path == "" {
+		path = "."
+	}
+
+	directory, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+
+	return directory.Sync()
+}
@@ -0,0 +1,25 @@
+package rdiff
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner gives file the owner and group of info when they differ from the ones file was created with.
+func copyOwner(file *os.File, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fileStat, ok := fileInfo.Sys().(*syscall.Stat_t); ok && fileStat.Uid == stat.Uid && fileStat.Gid == stat.Gid {
+		return nil
+	}
+
+	return file.Chown(int(stat.Uid), int(stat.Gid))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package rdiff
+
+import (
+	"os"
+)
+
+// copyOwner does nothing, file ownership is only kept on Linux.
+func copyOwner(file *os.File, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package rdiff
+
+import (
+	"bytes"
+	cryptoRand "crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchFile(t *testing.T) {
+	// Generate file
+	blockNumber, blockSize, _, originalFile, err := generateFile(2, 100)
+	assert.Nil(t, err)
+
+	// Modify one of the blocks
+	newFile := make([]byte, 0)
+	newFile = append(newFile, originalFile...)
+	blockIndex := rand64(0, int(blockNumber)-2)
+	_, err = cryptoRand.Read(newFile[blockIndex*blockSize : (blockIndex+1)*blockSize])
+	assert.Nil(t, err)
+
+	delta, err := generateDelta(originalFile, newFile, uint32(blockSize), Rabinkarp_Blake2b, 16, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	// Patch the file over itself and keep a backup
+	directory := t.TempDir()
+	path := filepath.Join(directory, "file")
+	assert.Nil(t, os.WriteFile(path, originalFile, 0640))
+	err = PatchFile(path, delta, path, &PatchFileOptions{Backup: true})
+	assert.Nil(t, err)
+
+	actualNewFile, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, actualNewFile)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	backupFile, err := os.ReadFile(path + DefaultBackupSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, originalFile, backupFile)
+
+	entries, err := os.ReadDir(directory)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestPatchFile_NewOutput(t *testing.T) {
+	// Generate file
+	_, blockSize, _, originalFile, err := generateFile(1, 100)
+	assert.Nil(t, err)
+
+	delta, err := generateDelta(originalFile, originalFile, uint32(blockSize), Rabinkarp_Md4, 8, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	// The output file takes the permissions of the original file, no backup is made for a new file
+	directory := t.TempDir()
+	originalPath := filepath.Join(directory, "original")
+	outputPath := filepath.Join(directory, "output")
+	assert.Nil(t, os.WriteFile(originalPath, originalFile, 0604))
+	err = PatchFile(originalPath, delta, outputPath, &PatchFileOptions{Backup: true, BackupSuffix: ".bak"})
+	assert.Nil(t, err)
+
+	actualNewFile, err := os.ReadFile(outputPath)
+	assert.Nil(t, err)
+	assert.Equal(t, originalFile, actualNewFile)
+	info, err := os.Stat(outputPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0604), info.Mode().Perm())
+
+	_, err = os.Stat(outputPath + ".bak")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPatchFile_InvalidDelta(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "file")
+	assert.Nil(t, os.WriteFile(path, []byte("original"), 0600))
+
+	// Truncated delta
+	err := PatchFile(path, bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 3, 'n', 'e'}), path, nil)
+	assert.NotNil(t, err)
+
+	// The file is untouched and the temporary file is removed
+	actualFile, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("original"), actualFile)
+	entries, err := os.ReadDir(directory)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestPatchFile_ModeBits(t *testing.T) {
+	// Generate file
+	_, blockSize, _, originalFile, err := generateFile(1, 100)
+	assert.Nil(t, err)
+
+	delta, err := generateDelta(originalFile, originalFile, uint32(blockSize), Rabinkarp_Md4, 8, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	// The setuid and setgid bits survive the change of owner, which root can test with another owner
+	directory := t.TempDir()
+	path := filepath.Join(directory, "file")
+	assert.Nil(t, os.WriteFile(path, originalFile, 0755))
+	if os.Getuid() == 0 {
+		assert.Nil(t, os.Chown(path, 1234, 1234))
+	}
+	mode := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid
+	assert.Nil(t, os.Chmod(path, mode))
+
+	err = PatchFile(path, delta, path, nil)
+	assert.Nil(t, err)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, mode, info.Mode())
+}
@@ -3,11 +3,14 @@ module github.com/otsybizov/librdiff-go
 go 1.20
 
 require (
-	github.com/balena-os/circbuf v0.1.3 // indirect
+	github.com/balena-os/circbuf v0.1.3
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.21.0
+)
+
+require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,273 @@
+package rdiff
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// inPlaceBufferSize is the size of the buffer PatchInPlace moves copied data with.
+const inPlaceBufferSize = 64 << 10
+
+// inPlaceMemorySize is the amount of literal data and buffered copy sources PatchInPlace keeps in memory, the rest
+// goes to a temporary scratch file.
+const inPlaceMemorySize = 1 << 20
+
+// InPlaceFile is a file that PatchInPlace can patch, *os.File implements it.
+type InPlaceFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+}
+
+type inPlaceCommand struct {
+	offset   int64
+	position int64
+	length   int64
+
+	// scratchPosition is the position of the literal data or the buffered copy source in the scratch.
+	scratchPosition int64
+
+	// deltaOffset and index locate the command in the delta file.
+	deltaOffset int64
+	index       int64
+
+	// successors lists the copies that overwrite the source of this copy and must wait for it.
+	successors  []int
+	predecessor int
+	done        bool
+}
+
+// PatchInPlace applies delta to file and replaces its content with the new file, like rsync --inplace. Copy
+// commands are ordered so that no original data is overwritten before every copy reading it is done. Copies that
+// depend on each other in a cycle are resolved by buffering the source of one of them. Literal data and buffered
+// sources are kept in memory up to 1 MiB and in a temporary scratch file beyond that, and are written after all
+// copies. Then the file is truncated or extended to the size of the new file.
+func PatchInPlace(file InPlaceFile, delta io.Reader) error {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	scratch := &inPlaceScratch{}
+	defer scratch.close()
+	buffer := make([]byte, inPlaceBufferSize)
+
+	var copies, literals []*inPlaceCommand
+	size := int64(0)
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		if command.Type == End {
+			break
+		}
+
+		c := &inPlaceCommand{offset: size, position: int64(command.Position), length: int64(command.Length), deltaOffset: reader.Offset(), index: reader.Index()}
+		if command.Type == Literal {
+			if c.scratchPosition, err = scratch.store(command.Data, c.length, buffer); err != nil {
+				return err
+			}
+			literals = append(literals, c)
+		} else if c.offset != c.position {
+			copies = append(copies, c)
+		}
+		size += c.length
+	}
+
+	// Copies are sorted by offset since the new file is written sequentially. Any copy whose destination overlaps
+	// the source of another copy has to wait for it.
+	for i, c := range copies {
+		begin := sort.Search(len(copies), func(j int) bool {
+			return copies[j].offset+copies[j].length > c.position
+		})
+		for j := begin; j < len(copies) && copies[j].offset < c.position+c.length; j++ {
+			if j != i {
+				c.successors = append(c.successors, j)
+				copies[j].predecessor++
+			}
+		}
+	}
+
+	var ready []int
+	for i, c := range copies {
+		if c.predecessor == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	for remaining := len(copies); remaining > 0; remaining-- {
+		var c *inPlaceCommand
+		if len(ready) > 0 {
+			c = copies[ready[len(ready)-1]]
+			ready = ready[:len(ready)-1]
+			if err = moveInPlace(file, c, buffer); err != nil {
+				return copyError(err, c.deltaOffset, c.index)
+			}
+		} else {
+			// Every remaining copy waits for another one, break the cycle by buffering the smallest source.
+			for _, candidate := range copies {
+				if !candidate.done && (c == nil || candidate.length < c.length) {
+					c = candidate
+				}
+			}
+
+			if c.scratchPosition, err = scratch.store(io.NewSectionReader(file, c.position, c.length), c.length, buffer); err != nil {
+				return copyError(err, c.deltaOffset, c.index)
+			}
+			literals = append(literals, c)
+		}
+
+		c.done = true
+		for _, successor := range c.successors {
+			copies[successor].predecessor--
+			if copies[successor].predecessor == 0 && !copies[successor].done {
+				ready = append(ready, successor)
+			}
+		}
+	}
+
+	for _, literal := range literals {
+		if err = scratch.writeTo(file, literal, buffer); err != nil {
+			return err
+		}
+	}
+
+	return file.Truncate(size)
+}
+
+// moveInPlace copies the source of c to its destination through buffer. When both ranges overlap the copy runs in
+// the direction that reads every chunk before it is overwritten.
+func moveInPlace(file InPlaceFile, c *inPlaceCommand, buffer []byte) error {
+	for done := int64(0); done < c.length; {
+		chunkLength := c.length - done
+		if chunkLength > int64(len(buffer)) {
+			chunkLength = int64(len(buffer))
+		}
+
+		chunkOffset := done
+		if c.offset > c.position {
+			chunkOffset = c.length - done - chunkLength
+		}
+
+		chunk := buffer[:chunkLength]
+		if err := readFullAt(file, chunk, c.position+chunkOffset); err != nil {
+			return err
+		}
+
+		if _, err := file.WriteAt(chunk, c.offset+chunkOffset); err != nil {
+			return err
+		}
+		done += chunkLength
+	}
+
+	return nil
+}
+
+func readFullAt(in io.ReaderAt, data []byte, position int64) error {
+	if n, err := in.ReadAt(data, position); n < len(data) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return nil
+}
+
+// inPlaceScratch holds the data PatchInPlace writes after all copies, the first inPlaceMemorySize bytes in memory
+// and the rest in a temporary file created on demand.
+type inPlaceScratch struct {
+	memory []byte
+	file   *os.File
+	size   int64
+}
+
+// store appends length bytes of in to the scratch through buffer and returns their position.
+func (s *inPlaceScratch) store(in io.Reader, length int64, buffer []byte) (int64, error) {
+	position := s.size
+	for length > 0 {
+		chunk := buffer
+		if int64(len(chunk)) > length {
+			chunk = chunk[:length]
+		}
+
+		if _, err := io.ReadFull(in, chunk); err != nil {
+			return 0, noEOF(err)
+		}
+
+		if err := s.write(chunk); err != nil {
+			return 0, err
+		}
+		length -= int64(len(chunk))
+	}
+
+	return position, nil
+}
+
+func (s *inPlaceScratch) write(data []byte) error {
+	if free := inPlaceMemorySize - len(s.memory); free > 0 {
+		if free > len(data) {
+			free = len(data)
+		}
+		s.memory = append(s.memory, data[:free]...)
+		s.size += int64(free)
+		data = data[free:]
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if s.file == nil {
+		file, err := os.CreateTemp("", "rdiff-inplace-*")
+		if err != nil {
+			return err
+		}
+		s.file = file
+	}
+
+	if _, err := s.file.WriteAt(data, s.size-int64(len(s.memory))); err != nil {
+		return err
+	}
+	s.size += int64(len(data))
+
+	return nil
+}
+
+// writeTo writes the scratch data of c to its destination in file through buffer.
+func (s *inPlaceScratch) writeTo(file InPlaceFile, c *inPlaceCommand, buffer []byte) error {
+	for done := int64(0); done < c.length; {
+		chunk := buffer
+		if int64(len(chunk)) > c.length-done {
+			chunk = chunk[:c.length-done]
+		}
+
+		position := c.scratchPosition + done
+		n := 0
+		if position < int64(len(s.memory)) {
+			n = copy(chunk, s.memory[position:])
+		}
+		if n < len(chunk) {
+			if err := readFullAt(s.file, chunk[n:], position+int64(n)-int64(len(s.memory))); err != nil {
+				return err
+			}
+		}
+
+		if _, err := file.WriteAt(chunk, c.offset+done); err != nil {
+			return err
+		}
+		done += int64(len(chunk))
+	}
+
+	return nil
+}
+
+func (s *inPlaceScratch) close() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
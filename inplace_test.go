@@ -0,0 +1,148 @@
+package rdiff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func patchInPlace(t *testing.T, originalFile []byte, delta *bytes.Buffer) []byte {
+	path := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(path, originalFile, 0600)
+	assert.Nil(t, err)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	err = PatchInPlace(file, delta)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	actualNewFile, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	return actualNewFile
+}
+
+func TestPatchInPlace_PrependFile(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		_, blockSize, _, originalFile, err := generateFile(2, 100)
+		assert.Nil(t, err)
+
+		// Insert random bytes at the beginning of the file, every block moves towards the end
+		insertData, err := generateBytes(rand64(1, int(blockSize*2)))
+		assert.Nil(t, err)
+		newFile := append(append([]byte{}, insertData...), originalFile...)
+
+		// Calculate delta
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		assert.Equal(t, newFile, patchInPlace(t, originalFile, delta))
+	}
+}
+
+func TestPatchInPlace_RemoveBlock(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(3, 100)
+		assert.Nil(t, err)
+
+		// Remove one of the blocks, the following blocks move towards the beginning and the file shrinks
+		blockIndex := rand64(0, int(blockNumber)-2)
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile[:blockIndex*blockSize]...)
+		newFile = append(newFile, originalFile[(blockIndex+1)*blockSize:]...)
+
+		// Calculate delta, the merged copy overlaps its own source
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+		canonicalDelta := &bytes.Buffer{}
+		err = CanonicalizeDelta(delta, canonicalDelta)
+		assert.Nil(t, err)
+
+		assert.Equal(t, newFile, patchInPlace(t, originalFile, canonicalDelta))
+	}
+}
+
+func TestPatchInPlace_SwapBlocks(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(4, 100)
+		assert.Nil(t, err)
+
+		// Swap two blocks, their copies depend on each other
+		first := rand64(0, int(blockNumber)-3)
+		second := rand64(int(first)+1, int(blockNumber)-2)
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile...)
+		copy(newFile[first*blockSize:(first+1)*blockSize], originalFile[second*blockSize:(second+1)*blockSize])
+		copy(newFile[second*blockSize:(second+1)*blockSize], originalFile[first*blockSize:(first+1)*blockSize])
+		newFile = append(newFile, []byte("appended")...)
+
+		// Calculate delta
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		assert.Equal(t, newFile, patchInPlace(t, originalFile, delta))
+	}
+}
+
+func TestPatchInPlace_RotateBlocks(t *testing.T) {
+	blockSize := uint64(100)
+	originalFile, err := generateBytes(blockSize * 5)
+	assert.Nil(t, err)
+
+	// Every block moves to the place of the next one, the last block moves to the beginning
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(4*blockSize, blockSize))
+	assert.Nil(t, writer.WriteCopy(0, 4*blockSize))
+	assert.Nil(t, writer.WriteCopy(150, 100))
+	assert.Nil(t, writer.Close())
+
+	newFile := make([]byte, 0)
+	newFile = append(newFile, originalFile[4*blockSize:]...)
+	newFile = append(newFile, originalFile[:4*blockSize]...)
+	newFile = append(newFile, originalFile[150:250]...)
+
+	assert.Equal(t, newFile, patchInPlace(t, originalFile, delta))
+}
+
+func TestPatchInPlace_Scratch(t *testing.T) {
+	// Literal data and the source of a copy cycle beyond inPlaceMemorySize go to a scratch file that is removed
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	half := uint64(inPlaceMemorySize * 3 / 2)
+	originalFile, err := generateBytes(half * 2)
+	assert.Nil(t, err)
+	literalData, err := generateBytes(half)
+	assert.Nil(t, err)
+
+	// The halves swap places, the literal data follow
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(half, half))
+	assert.Nil(t, writer.WriteCopy(0, half))
+	assert.Nil(t, writer.WriteLiteral(literalData))
+	assert.Nil(t, writer.Close())
+
+	newFile := make([]byte, 0)
+	newFile = append(newFile, originalFile[half:]...)
+	newFile = append(newFile, originalFile[:half]...)
+	newFile = append(newFile, literalData...)
+
+	assert.Equal(t, newFile, patchInPlace(t, originalFile, delta))
+
+	entries, err := os.ReadDir(tempDir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), "rdiff-inplace-")
+	}
+}
@@ -0,0 +1,61 @@
+package rdiff
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteVCDIFF_Tools checks that xdelta3 and the vcdiff tool of open-vcdiff decode the deltas WriteVCDIFF writes.
+func TestWriteVCDIFF_Tools(t *testing.T) {
+	tools := map[string]func(source, delta, target string) *exec.Cmd{
+		"xdelta3": func(source, delta, target string) *exec.Cmd {
+			return exec.Command("xdelta3", "-d", "-f", "-s", source, delta, target)
+		},
+		"vcdiff": func(source, delta, target string) *exec.Cmd {
+			return exec.Command("vcdiff", "decode", "-dictionary", source, "-delta", delta, "-target", target)
+		},
+	}
+
+	// Generate file, with a long run and more than one target window
+	_, blockSize, _, originalFile, err := generateFile(10, 100)
+	assert.Nil(t, err)
+	newFile := generateSparseChanges(originalFile, int(blockSize)*3)
+	newFile = append(newFile, make([]byte, 1000)...)
+	for len(newFile) <= vcdiffMaxWindowSize {
+		newFile = append(newFile, newFile...)
+	}
+
+	delta, err := generateDelta(originalFile, newFile, uint32(blockSize), Rollsum_Blake2b, 8, DefaultMaxLiteralSize)
+	assert.Nil(t, err)
+	vcdiff := &bytes.Buffer{}
+	assert.Nil(t, WriteVCDIFF(delta, vcdiff))
+
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source")
+	deltaPath := filepath.Join(dir, "target.vcdiff")
+	assert.Nil(t, os.WriteFile(sourcePath, originalFile, 0600))
+	assert.Nil(t, os.WriteFile(deltaPath, vcdiff.Bytes(), 0600))
+
+	for tool, command := range tools {
+		t.Run(tool, func(t *testing.T) {
+			if _, err := exec.LookPath(tool); err != nil {
+				t.Skipf("%s is not on the PATH: %v", tool, err)
+			}
+
+			targetPath := filepath.Join(dir, tool+".target")
+			output, err := command(sourcePath, deltaPath, targetPath).CombinedOutput()
+			if !assert.Nil(t, err, string(output)) {
+				return
+			}
+
+			actualNewFile, err := os.ReadFile(targetPath)
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, actualNewFile)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package rdiff
+
+import (
+	"io"
+)
+
+// optimizeChunkSize is the max size of the literal data read at once, literal data grows with what the delta
+// actually holds instead of the length its commands claim.
+const optimizeChunkSize = 64 << 10
+
+type deltaOptimizer struct {
+	writer         *DeltaWriter
+	maxLiteralSize uint64
+	literalData    []byte
+	copyPosition   uint64
+	copyLength     uint64
+}
+
+func (o *deltaOptimizer) addLiteral(data io.Reader, length uint64) error {
+	if err := o.flushCopy(); err != nil {
+		return err
+	}
+
+	for length > 0 {
+		chunkSize := length
+		if chunkSize > optimizeChunkSize {
+			chunkSize = optimizeChunkSize
+		}
+		if o.maxLiteralSize > 0 && uint64(len(o.literalData))+chunkSize > o.maxLiteralSize {
+			chunkSize = o.maxLiteralSize - uint64(len(o.literalData))
+		}
+
+		size := uint64(len(o.literalData))
+		o.literalData = append(o.literalData, make([]byte, chunkSize)...)
+		if _, err := io.ReadFull(data, o.literalData[size:]); err != nil {
+			return noEOF(err)
+		}
+		length -= chunkSize
+
+		if o.maxLiteralSize > 0 && uint64(len(o.literalData)) == o.maxLiteralSize {
+			if err := o.flushLiteral(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *deltaOptimizer) addCopy(position, length uint64) error {
+	if length == 0 {
+		return nil
+	}
+
+	if err := o.flushLiteral(); err != nil {
+		return err
+	}
+
+	if o.copyLength > 0 && o.copyPosition+o.copyLength == position {
+		o.copyLength += length
+		return nil
+	}
+
+	if err := o.flushCopy(); err != nil {
+		return err
+	}
+	o.copyPosition = position
+	o.copyLength = length
+
+	return nil
+}
+
+func (o *deltaOptimizer) flushLiteral() error {
+	if len(o.literalData) == 0 {
+		return nil
+	}
+
+	err := o.writer.WriteLiteral(o.literalData)
+	o.literalData = o.literalData[:0]
+
+	return err
+}
+
+func (o *deltaOptimizer) flushCopy() error {
+	if o.copyLength == 0 {
+		return nil
+	}
+
+	err := o.writer.WriteCopy(o.copyPosition, o.copyLength)
+	o.copyLength = 0
+
+	return err
+}
+
+// OptimizeDelta re-encodes delta into out. Adjacent literal commands are merged, copy commands of contiguous
+// original ranges are merged, empty commands are dropped and every parameter uses the narrowest width. Literal
+// runs are buffered in memory and written as commands of at most maxLiteralSize bytes, a maxLiteralSize of 0 never
+// splits them.
+func OptimizeDelta(delta io.Reader, out io.Writer, maxLiteralSize uint32) error {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	writer, err := NewDeltaWriter(out)
+	if err != nil {
+		return err
+	}
+
+	optimizer := &deltaOptimizer{writer: writer, maxLiteralSize: uint64(maxLiteralSize)}
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		switch command.Type {
+		case End:
+			if err = optimizer.flushLiteral(); err != nil {
+				return err
+			}
+			if err = optimizer.flushCopy(); err != nil {
+				return err
+			}
+			return writer.Close()
+		case Literal:
+			err = optimizer.addLiteral(command.Data, command.Length)
+		case Copy:
+			err = optimizer.addCopy(command.Position, command.Length)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// CanonicalizeDelta writes the canonical form of delta into out: the optimized delta with every literal run in a
+// single command. Deltas describing the same changes of the same original file encode to identical bytes as long as
+// they split the new file between literal and copy commands the same way.
+func CanonicalizeDelta(delta io.Reader, out io.Writer) error {
+	return OptimizeDelta(delta, out, 0)
+}
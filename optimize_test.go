@@ -0,0 +1,94 @@
+package rdiff
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimizeDelta(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		_, blockSize, lastBlockSize, originalFile, err := generateFile(2, 100)
+		assert.Nil(t, err)
+
+		// Prepend random bytes, the delta has split literals and a copy command per block
+		insertData, err := generateBytes(rand64(100, 1000))
+		assert.Nil(t, err)
+		newFile := append(append([]byte{}, insertData...), originalFile...)
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, 10)
+		assert.Nil(t, err)
+
+		// Optimize delta
+		optimizedDelta := &bytes.Buffer{}
+		err = OptimizeDelta(bytes.NewReader(delta.Bytes()), optimizedDelta, uint32(blockSize))
+		assert.Nil(t, err)
+		assert.Less(t, optimizedDelta.Len(), delta.Len())
+
+		actualNewFile := &bytes.Buffer{}
+		err = Patch(bytes.NewReader(originalFile), actualNewFile, optimizedDelta)
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, actualNewFile.Bytes())
+
+		// Canonicalize delta
+		fullBlocksLength := uint64(len(originalFile)) - lastBlockSize
+		expectedDelta := &bytes.Buffer{}
+		writer, err := NewDeltaWriter(expectedDelta)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteLiteral(insertData))
+		assert.Nil(t, writer.WriteCopy(0, fullBlocksLength))
+		assert.Nil(t, writer.WriteLiteral(originalFile[fullBlocksLength:]))
+		assert.Nil(t, writer.Close())
+
+		canonicalDelta := &bytes.Buffer{}
+		err = CanonicalizeDelta(bytes.NewReader(delta.Bytes()), canonicalDelta)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedDelta, canonicalDelta)
+	}
+}
+
+func TestCanonicalizeDelta_SameChanges(t *testing.T) {
+	literalData, err := generateBytes(300)
+	assert.Nil(t, err)
+
+	// Split literals, unmerged copies, empty commands and wide parameters
+	firstDelta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(firstDelta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral(literalData[:100]))
+	assert.Nil(t, writer.WriteLiteral(literalData[100:]))
+	assert.Nil(t, writer.WriteCopy(1000, 500))
+	assert.Nil(t, writer.WriteCopy(1500, 0))
+	assert.Nil(t, writer.WriteCopy(1500, 500))
+	assert.Nil(t, writer.WriteCopy(0, 10))
+	assert.Nil(t, writer.Close())
+
+	secondDelta := &bytes.Buffer{}
+	writer, err = NewDeltaWriter(secondDelta)
+	assert.Nil(t, err)
+	for begin := 0; begin < len(literalData); begin += 7 {
+		end := begin + 7
+		if end > len(literalData) {
+			end = len(literalData)
+		}
+		assert.Nil(t, writer.WriteLiteral(literalData[begin:end]))
+	}
+	secondDelta.Write([]byte{MinCopyCommand + 15, 0, 0, 0, 0, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0x03, 0xe8})
+	assert.Nil(t, writer.WriteCopy(0, 10))
+	assert.Nil(t, writer.Close())
+
+	firstCanonicalDelta := &bytes.Buffer{}
+	assert.Nil(t, CanonicalizeDelta(firstDelta, firstCanonicalDelta))
+	secondCanonicalDelta := &bytes.Buffer{}
+	assert.Nil(t, CanonicalizeDelta(secondDelta, secondCanonicalDelta))
+	assert.Equal(t, firstCanonicalDelta, secondCanonicalDelta)
+}
+
+func TestCanonicalizeDelta_TruncatedLiteral(t *testing.T) {
+	// A literal command claiming far more data than the delta holds fails without allocating its length
+	delta := []byte{0x72, 0x73, 0x02, 0x36, MinParameterizedLiteralCommand + 3, 0x40, 0, 0, 0, 0, 0, 0, 0, 0xaa, 0xbb}
+	err := CanonicalizeDelta(bytes.NewReader(delta), &bytes.Buffer{})
+	assert.True(t, errors.Is(err, ErrTruncatedDelta))
+}
@@ -1,98 +1,196 @@
 package rdiff
 
 import (
-	"encoding/binary"
-	"fmt"
 	"io"
 )
 
-func getParamSize(commandOffset byte) byte {
-	switch commandOffset {
-	case 0:
-		return 1
-	case 1:
-		return 2
-	case 2:
-		return 4
-	default:
-		return 8
-	}
+// PatchOptions configures PatchWithOptions. The limits bound what a delta may ask Patch to do, which protects
+// against hostile deltas. A zero limit means no limit.
+type PatchOptions struct {
+	// MaxOutputSize is the max size of the new file.
+	MaxOutputSize int64
+
+	// MaxCommandLength is the max length of a single literal or copy command.
+	MaxCommandLength int64
+
+	// MaxOriginalOffset is the max end offset of the original file range a copy command reads.
+	MaxOriginalOffset int64
+
+	// Checkpoints receives a checkpoint every CheckpointInterval bytes of output. It is used by ResumePatch only,
+	// PatchWithOptions ignores it.
+	Checkpoints CheckpointStore
+
+	// CheckpointInterval is the number of bytes written between checkpoints, DefaultCheckpointInterval if 0.
+	CheckpointInterval int64
+
+	// ReadAhead is the number of commands decoded ahead of the current one. Nearby original file ranges of the
+	// upcoming copy commands are then read at once and cached. 0 disables read-ahead, every copy command then
+	// seeks and reads the original file on its own.
+	ReadAhead int
+
+	// ReadAheadGap is the max number of unused bytes between two copy ranges read at once.
+	ReadAheadGap int64
+
+	// Signature is the signature the delta was made from. When set, the blocks of the original file a copy command
+	// reads are checked against their strong checksums before the copy is written, every block once, and the first
+	// changed block is reported as a *StaleBlockError.
+	Signature *Signature
+
+	// CacheSize bounds the memory used for read-ahead literal data and cached original file data,
+	// DefaultCacheSize if 0.
+	CacheSize int64
 }
 
-func readParam(in io.Reader, commandOffset byte) (int64, error) {
-	switch getParamSize(commandOffset) {
-	case 1:
-		var value uint8
-		err := binary.Read(in, binary.BigEndian, &value)
-		return int64(value), err
-	case 2:
-		var value uint16
-		err := binary.Read(in, binary.BigEndian, &value)
-		return int64(value), err
-	case 4:
-		var value uint32
-		err := binary.Read(in, binary.BigEndian, &value)
-		return int64(value), err
-	default:
-		var value uint64
-		err := binary.Read(in, binary.BigEndian, &value)
-		return int64(value), err
+// check returns a *LimitError if command, written after outputSize bytes of the new file, exceeds a limit.
+func (o *PatchOptions) check(command *DeltaCommand, outputSize uint64) error {
+	if o.MaxCommandLength > 0 && command.Length > uint64(o.MaxCommandLength) {
+		return &LimitError{Limit: "MaxCommandLength", Value: command.Length, Max: o.MaxCommandLength}
+	}
+
+	if o.MaxOutputSize > 0 && outputSize+command.Length > uint64(o.MaxOutputSize) {
+		return &LimitError{Limit: "MaxOutputSize", Value: outputSize + command.Length, Max: o.MaxOutputSize}
+	}
+
+	end := command.Position + command.Length
+	if command.Type == Copy && o.MaxOriginalOffset > 0 && end > uint64(o.MaxOriginalOffset) {
+		return &LimitError{Limit: "MaxOriginalOffset", Value: end, Max: o.MaxOriginalOffset}
 	}
+
+	return nil
 }
 
 func Patch(originalFile io.ReadSeeker, newFile io.Writer, delta io.Reader) error {
-	var err error
-	var deltaFormat uint32
-	if err = binary.Read(delta, binary.BigEndian, &deltaFormat); err != nil {
+	return PatchWithOptions(originalFile, newFile, delta, nil)
+}
+
+// PatchWithOptions applies delta like Patch and stops with a *DeltaError wrapping a *LimitError at the first
+// command that exceeds a limit of options, before any of its data is written. Options may be nil.
+// PatchWithOptions does not write checkpoints, use ResumePatch for a patch that can be resumed.
+func PatchWithOptions(originalFile io.ReadSeeker, newFile io.Writer, delta io.Reader, options *PatchOptions) error {
+	if options == nil {
+		options = &PatchOptions{}
+	} else if options.Checkpoints != nil {
+		withoutCheckpoints := *options
+		withoutCheckpoints.Checkpoints = nil
+		options = &withoutCheckpoints
+	}
+
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
 		return err
 	}
-	if deltaFormat != DeltaMagicNumber {
-		return fmt.Errorf("invalid delta format %x, expected %x", deltaFormat, DeltaMagicNumber)
+
+	return newPatcher(newSeekSource(originalFile, options), newFile, reader, options).run()
+}
+
+func newPatcher(source originalSource, newFile io.Writer, reader *DeltaReader, options *PatchOptions) *patcher {
+	return &patcher{source: source, newFile: newFile, reader: reader, options: options}
+}
+
+type patcher struct {
+	source  originalSource
+	newFile io.Writer
+	reader  *DeltaReader
+	options *PatchOptions
+
+	// verifier checks the original file against options.Signature.
+	verifier *blockVerifier
+
+	// queue holds the commands decoded ahead, queued is the size of the literal data read ahead into memory and
+	// err is the decoding error to report once the queue is empty.
+	queue  []*patchCommand
+	queued int64
+	err    error
+
+	// outputSize is the number of bytes written to the new file.
+	outputSize uint64
+
+	// checkpointSize is the output size at the last checkpoint.
+	checkpointSize uint64
+
+	// progress is the number of bytes of the next command written before the patch was resumed.
+	progress uint64
+
+	// deltaFingerprint and originalFingerprint identify the files in checkpoints.
+	deltaFingerprint    Fingerprint
+	originalFingerprint Fingerprint
+}
+
+func (p *patcher) run() error {
+	if p.options.Signature != nil {
+		verifier, err := newBlockVerifier(p.options.Signature)
+		if err != nil {
+			return err
+		}
+		p.verifier = verifier
 	}
 
 	for {
-		var cmdCode byte
-		if err = binary.Read(delta, binary.BigEndian, &cmdCode); err != nil {
+		command, err := p.next()
+		if err != nil {
 			return err
 		}
 
-		if CommandType(cmdCode) == End {
-			return nil
-		} else if cmdCode >= MinReservedCommand {
-			return fmt.Errorf("unsupported command code %d", cmdCode)
+		progress := p.progress
+		p.progress = 0
+		if progress > command.Length {
+			return deltaError(ErrCheckpointMismatch, command.offset, command.index)
+		}
+		if err = p.options.check(command.DeltaCommand, p.outputSize-progress); err != nil {
+			return &DeltaError{Offset: command.offset, Command: command.index, Err: err}
 		}
 
-		var position, length int64
-		if cmdCode < MinParameterizedLiteralCommand {
-			length = int64(cmdCode)
-		} else if cmdCode >= MinParameterizedLiteralCommand && cmdCode < MinCopyCommand {
-			if length, err = readParam(delta, cmdCode-MinParameterizedLiteralCommand); err != nil {
+		switch command.Type {
+		case End:
+			return p.finish()
+		case Literal:
+			if _, err = io.CopyN(io.Discard, command.Data, int64(progress)); err != nil {
 				return err
 			}
-		} else {
-			offset := cmdCode - MinCopyCommand
-			positionOffset := offset / 4
-			lengthOffset := offset - positionOffset*4
-			if position, err = readParam(delta, positionOffset); err != nil {
-				return err
+		case Copy:
+			if p.verifier != nil {
+				if err = p.verifier.verify(p.source, int64(command.Position), int64(command.Length)); err != nil {
+					return deltaError(err, command.offset, command.index)
+				}
 			}
-			if length, err = readParam(delta, lengthOffset); err != nil {
-				return err
+		}
+
+		if err = p.write(command, progress); err != nil {
+			return err
+		}
+	}
+}
+
+// write writes the data of command after the first done bytes. With a checkpoint store, the data are written in
+// pieces that end where a checkpoint is due, so that a long command is resumable too.
+func (p *patcher) write(command *patchCommand, done uint64) error {
+	for done < command.Length {
+		length := command.Length - done
+		if p.options.Checkpoints != nil {
+			if due := p.checkpointSize + p.checkpointInterval() - p.outputSize; length > due {
+				length = due
 			}
 		}
 
-		if cmdCode < MinCopyCommand {
-			if _, err = io.CopyN(newFile, delta, length); err != nil {
+		if command.Type == Literal {
+			if _, err := io.CopyN(p.newFile, command.Data, int64(length)); err != nil {
 				return err
 			}
 		} else {
-			if _, err = originalFile.Seek(position, io.SeekStart); err != nil {
-				return err
+			position := int64(command.Position + done)
+			if err := p.source.copyTo(p.newFile, position, int64(length), p.queue); err != nil {
+				return copyError(err, command.offset, command.index)
 			}
+		}
+		done += length
+		p.outputSize += length
 
-			if _, err = io.CopyN(newFile, originalFile, length); err != nil {
+		if done < command.Length {
+			if err := p.checkpoint(command.offset, command.index, done); err != nil {
 				return err
 			}
 		}
 	}
+
+	return p.checkpoint(command.end, command.index+1, 0)
 }
@@ -3,6 +3,7 @@ package rdiff
 import (
 	"bytes"
 	cryptoRand "crypto/rand"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,7 +12,7 @@ import (
 func TestPatch_NoChange(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -37,7 +38,7 @@ func TestPatch_NoChange(t *testing.T) {
 func TestPatch_PrependFile(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -70,7 +71,7 @@ func TestPatch_PrependFile(t *testing.T) {
 func TestPatch_AppendFile(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -103,13 +104,13 @@ func TestPatch_AppendFile(t *testing.T) {
 func TestPatch_InsertBetweenBlocks(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
 
 			// Generate file
-			blockNumber, blockSize, _, originalFile, err := generateFile(2, 100)
+			blockNumber, blockSize, _, originalFile, err := generateFile(3, 100)
 			assert.Nil(t, err)
 
 			// Insert random bytes at the beginning of a block
@@ -138,7 +139,7 @@ func TestPatch_InsertBetweenBlocks(t *testing.T) {
 func TestPatch_ModifyBlock(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -173,7 +174,7 @@ func TestPatch_ModifyBlock(t *testing.T) {
 func TestPatch_RemoveBlock(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -207,7 +208,7 @@ func TestPatch_RemoveBlock(t *testing.T) {
 func TestPatch_SmallMaxLiteralSize(t *testing.T) {
 	for _, checksumType := range ChecksumTypes {
 		for _, strongChecksumSize := range StrongChecksumSizes {
-			checksum, err := NewChecksum(checksumType)
+			checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
 			if maxStrongChecksumSize := checksum.MaxStrongChecksumSize(); strongChecksumSize > maxStrongChecksumSize {
 				continue
 			}
@@ -231,3 +232,91 @@ func TestPatch_SmallMaxLiteralSize(t *testing.T) {
 		}
 	}
 }
+
+func TestPatch_ModifyTwoBlocks(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(4, 100)
+		assert.Nil(t, err)
+
+		// Modify the first block and one of the following blocks
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile...)
+		_, err = cryptoRand.Read(newFile[:blockSize])
+		assert.Nil(t, err)
+		blockIndex := rand64(2, int(blockNumber)-2)
+		_, err = cryptoRand.Read(newFile[blockIndex*blockSize : (blockIndex+1)*blockSize])
+		assert.Nil(t, err)
+
+		// Calculate delta with literals split in the middle of the file
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize/3))
+		assert.Nil(t, err)
+
+		// Apply patch
+		actualNewFile := &bytes.Buffer{}
+		err = Patch(bytes.NewReader(originalFile), actualNewFile, delta)
+		assert.Nil(t, err)
+
+		assert.Equal(t, newFile, actualNewFile.Bytes())
+	}
+}
+
+func TestPatchWithOptions_Limits(t *testing.T) {
+	originalFile, err := generateBytes(1000)
+	assert.Nil(t, err)
+
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral(originalFile[:100]))
+	assert.Nil(t, writer.WriteCopy(500, 300))
+	assert.Nil(t, writer.Close())
+
+	testCases := []struct {
+		options *PatchOptions
+		limit   string
+	}{
+		{&PatchOptions{MaxOutputSize: 399}, "MaxOutputSize"},
+		{&PatchOptions{MaxCommandLength: 299}, "MaxCommandLength"},
+		{&PatchOptions{MaxOriginalOffset: 799}, "MaxOriginalOffset"},
+	}
+
+	for _, testCase := range testCases {
+		actualNewFile := &bytes.Buffer{}
+		err = PatchWithOptions(bytes.NewReader(originalFile), actualNewFile, bytes.NewReader(delta.Bytes()), testCase.options)
+		assert.True(t, errors.Is(err, ErrLimitExceeded))
+		var limitError *LimitError
+		assert.True(t, errors.As(err, &limitError))
+		assert.Equal(t, testCase.limit, limitError.Limit)
+		var deltaError *DeltaError
+		assert.True(t, errors.As(err, &deltaError))
+		assert.Equal(t, int64(1), deltaError.Command)
+
+		// Nothing of the rejected command is written
+		assert.Equal(t, originalFile[:100], actualNewFile.Bytes())
+	}
+
+	// Limits that are not exceeded
+	actualNewFile := &bytes.Buffer{}
+	options := &PatchOptions{MaxOutputSize: 400, MaxCommandLength: 300, MaxOriginalOffset: 800}
+	err = PatchWithOptions(bytes.NewReader(originalFile), actualNewFile, delta, options)
+	assert.Nil(t, err)
+	assert.Equal(t, append(originalFile[:100:100], originalFile[500:800]...), actualNewFile.Bytes())
+}
+
+func TestPatch_ParameterOverflow(t *testing.T) {
+	// Copy command with 8 bytes position and 1 byte length
+	delta := []byte{0x72, 0x73, 0x02, 0x36, MinCopyCommand + 12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xf0, 0x10, 0}
+	err := Patch(bytes.NewReader(make([]byte, 100)), &bytes.Buffer{}, bytes.NewReader(delta))
+	assert.True(t, errors.Is(err, ErrParameterOverflow))
+
+	// Copy command whose end overflows
+	delta = []byte{0x72, 0x73, 0x02, 0x36, MinCopyCommand + 12, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xf0, 0x10, 0}
+	err = Patch(bytes.NewReader(make([]byte, 100)), &bytes.Buffer{}, bytes.NewReader(delta))
+	assert.True(t, errors.Is(err, ErrParameterOverflow))
+
+	// Literal command with 8 bytes length
+	delta = []byte{0x72, 0x73, 0x02, 0x36, MinParameterizedLiteralCommand + 3, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}
+	err = Patch(bytes.NewReader(make([]byte, 100)), &bytes.Buffer{}, bytes.NewReader(delta))
+	assert.True(t, errors.Is(err, ErrParameterOverflow))
+}
@@ -0,0 +1,142 @@
+package rdiff
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// patchChunkSize is the max number of bytes a single PatchAt job reads and writes.
+const patchChunkSize = 1 << 20
+
+type patchJob struct {
+	// literalData holds the payload of a literal, a copy job reads the original file when it is nil.
+	literalData []byte
+	position    int64
+	offset      int64
+	length      int64
+
+	// deltaOffset and index locate the command of the job in the delta file.
+	deltaOffset int64
+	index       int64
+}
+
+type patchWorkers struct {
+	originalFile io.ReaderAt
+	newFile      io.WriterAt
+	jobs         chan *patchJob
+	done         chan struct{}
+	wait         sync.WaitGroup
+	once         sync.Once
+	err          error
+}
+
+func (p *patchWorkers) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.done)
+	})
+}
+
+func (p *patchWorkers) run() {
+	defer p.wait.Done()
+
+	buffer := make([]byte, patchChunkSize)
+	for job := range p.jobs {
+		data := job.literalData
+		if data == nil {
+			data = buffer[:job.length]
+			if err := readFullAt(p.originalFile, data, job.position); err != nil {
+				p.fail(copyError(err, job.deltaOffset, job.index))
+				continue
+			}
+		}
+
+		if _, err := p.newFile.WriteAt(data, job.offset); err != nil {
+			p.fail(err)
+		}
+	}
+}
+
+// submit queues a job and reports false once a worker has failed.
+func (p *patchWorkers) submit(job *patchJob) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// PatchAt applies delta like Patch but reads the original file through io.ReaderAt and writes the new file through
+// io.WriterAt, so the same original file can be shared by concurrent jobs. The delta is scanned sequentially to
+// compute the offset of every command in the new file while a pool of workers goroutines copies the ranges in
+// chunks of up to 1 MiB. A workers value of 0 or less uses one worker per CPU.
+func PatchAt(originalFile io.ReaderAt, newFile io.WriterAt, delta io.Reader, workers int) error {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	pool := &patchWorkers{
+		originalFile: originalFile,
+		newFile:      newFile,
+		jobs:         make(chan *patchJob, workers),
+		done:         make(chan struct{}),
+	}
+	pool.wait.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.run()
+	}
+
+	err = scanPatchJobs(reader, pool)
+	close(pool.jobs)
+	pool.wait.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	return pool.err
+}
+
+func scanPatchJobs(reader *DeltaReader, pool *patchWorkers) error {
+	offset := int64(0)
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		if command.Type == End {
+			return nil
+		}
+
+		length := int64(command.Length)
+		for chunkOffset := int64(0); chunkOffset < length; chunkOffset += patchChunkSize {
+			chunkLength := length - chunkOffset
+			if chunkLength > patchChunkSize {
+				chunkLength = patchChunkSize
+			}
+
+			job := &patchJob{offset: offset + chunkOffset, length: chunkLength, deltaOffset: reader.Offset(), index: reader.Index()}
+			if command.Type == Literal {
+				job.literalData = make([]byte, chunkLength)
+				if _, err = io.ReadFull(command.Data, job.literalData); err != nil {
+					return noEOF(err)
+				}
+			} else {
+				job.position = int64(command.Position) + chunkOffset
+			}
+
+			if !pool.submit(job) {
+				return nil
+			}
+		}
+		offset += length
+	}
+}
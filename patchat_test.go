@@ -0,0 +1,74 @@
+package rdiff
+
+import (
+	"bytes"
+	cryptoRand "crypto/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryFile is an in-memory io.WriterAt that can be written concurrently.
+type memoryFile struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (f *memoryFile) WriteAt(p []byte, offset int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if end := int(offset) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[offset:], p)
+
+	return len(p), nil
+}
+
+func TestPatchAt(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		for _, workers := range []int{0, 1, 4} {
+			// Generate file
+			blockNumber, blockSize, _, originalFile, err := generateFile(3, 100)
+			assert.Nil(t, err)
+
+			// Modify one of the blocks and insert random bytes at the beginning of the file
+			newFile := make([]byte, 0)
+			newFile = append(newFile, originalFile...)
+			blockIndex := rand64(0, int(blockNumber)-2)
+			_, err = cryptoRand.Read(newFile[blockIndex*blockSize : (blockIndex+1)*blockSize])
+			assert.Nil(t, err)
+			insertData, err := generateBytes(rand64(1, 1000))
+			assert.Nil(t, err)
+			newFile = append(insertData, newFile...)
+
+			// Calculate delta
+			delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+			assert.Nil(t, err)
+			optimizedDelta := &bytes.Buffer{}
+			err = CanonicalizeDelta(delta, optimizedDelta)
+			assert.Nil(t, err)
+
+			// Apply patch
+			actualNewFile := &memoryFile{}
+			err = PatchAt(bytes.NewReader(originalFile), actualNewFile, optimizedDelta, workers)
+			assert.Nil(t, err)
+
+			assert.Equal(t, newFile, actualNewFile.data)
+		}
+	}
+}
+
+func TestPatchAt_CopyOutOfRange(t *testing.T) {
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral([]byte{1, 2, 3}))
+	assert.Nil(t, writer.WriteCopy(90, 20))
+	assert.Nil(t, writer.Close())
+
+	err = PatchAt(bytes.NewReader(make([]byte, 100)), &memoryFile{}, delta, 2)
+	assert.NotNil(t, err)
+}
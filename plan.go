@@ -0,0 +1,77 @@
+package rdiff
+
+import (
+	"io"
+	"math"
+	"sort"
+)
+
+// DeltaPlan describes what applying a delta takes and produces.
+type DeltaPlan struct {
+	// OutputSize is the size of the new file.
+	OutputSize int64
+
+	// LiteralBytes is the number of bytes of the new file carried as literal data.
+	LiteralBytes int64
+
+	// Ranges are the original file ranges read by the copy commands, sorted by offset with overlapping and
+	// adjacent ranges merged.
+	Ranges []Range
+}
+
+// AnalyzeDelta reads delta without the original file and returns its plan. Literal data are skipped. A delta whose
+// output size does not fit in an int64 is reported as ErrParameterOverflow.
+func AnalyzeDelta(delta io.Reader) (*DeltaPlan, error) {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &DeltaPlan{}
+	var ranges []Range
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if command.Length > math.MaxInt64-uint64(plan.OutputSize) {
+			return nil, deltaError(ErrParameterOverflow, reader.offset, reader.index)
+		}
+
+		switch command.Type {
+		case End:
+			plan.Ranges = mergeRanges(ranges)
+			return plan, nil
+		case Literal:
+			plan.LiteralBytes += int64(command.Length)
+		case Copy:
+			ranges = append(ranges, Range{Offset: int64(command.Position), Length: int64(command.Length)})
+		}
+		plan.OutputSize += int64(command.Length)
+	}
+}
+
+// mergeRanges sorts ranges by offset and merges the overlapping and adjacent ones. Empty ranges are dropped.
+func mergeRanges(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Offset < ranges[j].Offset
+	})
+
+	var merged []Range
+	for _, r := range ranges {
+		if r.Length == 0 {
+			continue
+		}
+
+		if last := len(merged) - 1; last >= 0 && r.Offset <= merged[last].Offset+merged[last].Length {
+			if end := r.Offset + r.Length; end > merged[last].Offset+merged[last].Length {
+				merged[last].Length = end - merged[last].Offset
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
@@ -0,0 +1,86 @@
+package rdiff
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeDelta(t *testing.T) {
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(1000, 100))
+	assert.Nil(t, writer.WriteLiteral(make([]byte, 300)))
+	assert.Nil(t, writer.WriteCopy(0, 10))
+	assert.Nil(t, writer.WriteCopy(1100, 50))
+	assert.Nil(t, writer.WriteCopy(1020, 10))
+	assert.Nil(t, writer.WriteLiteral([]byte{1, 2, 3}))
+	assert.Nil(t, writer.WriteCopy(5, 10))
+	assert.Nil(t, writer.WriteCopy(1<<40, 1<<20))
+	assert.Nil(t, writer.Close())
+
+	plan, err := AnalyzeDelta(delta)
+	assert.Nil(t, err)
+
+	expectedPlan := &DeltaPlan{
+		OutputSize:   100 + 300 + 10 + 50 + 10 + 3 + 10 + 1<<20,
+		LiteralBytes: 303,
+		Ranges:       []Range{{0, 15}, {1000, 150}, {1 << 40, 1 << 20}},
+	}
+	assert.Equal(t, expectedPlan, plan)
+}
+
+func TestAnalyzeDelta_Overflow(t *testing.T) {
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral([]byte{1}))
+	assert.Nil(t, writer.WriteCopy(0, 1<<62))
+	assert.Nil(t, writer.WriteCopy(1<<62, 1<<62-2))
+	assert.Nil(t, writer.WriteCopy(0, 1))
+	assert.Nil(t, writer.Close())
+
+	// The output size reaches math.MaxInt64 before the last copy, which makes it overflow
+	_, err = AnalyzeDelta(delta)
+	assert.True(t, errors.Is(err, ErrParameterOverflow))
+	deltaErr := &DeltaError{}
+	assert.True(t, errors.As(err, &deltaErr))
+	assert.Equal(t, int64(3), deltaErr.Command)
+}
+
+func TestAnalyzeDelta_GeneratedDelta(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, lastBlockSize, originalFile, err := generateFile(3, 100)
+		assert.Nil(t, err)
+
+		// Remove one of the blocks and append random bytes at the end of the file
+		blockIndex := rand64(0, int(blockNumber)-2)
+		insertData, err := generateBytes(rand64(1, 100))
+		assert.Nil(t, err)
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile[:blockIndex*blockSize]...)
+		newFile = append(newFile, originalFile[(blockIndex+1)*blockSize:]...)
+		newFile = append(newFile, insertData...)
+
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		plan, err := AnalyzeDelta(delta)
+		assert.Nil(t, err)
+
+		assert.Equal(t, int64(len(newFile)), plan.OutputSize)
+		assert.Equal(t, int64(lastBlockSize+uint64(len(insertData))), plan.LiteralBytes)
+		var expectedRanges []Range
+		if blockIndex > 0 {
+			expectedRanges = append(expectedRanges, Range{0, int64(blockIndex * blockSize)})
+		}
+		if blockIndex < blockNumber-2 {
+			expectedRanges = append(expectedRanges, Range{int64((blockIndex + 1) * blockSize), int64((blockNumber - 2 - blockIndex) * blockSize)})
+		}
+		assert.Equal(t, expectedRanges, plan.Ranges)
+	}
+}
@@ -13,14 +13,38 @@ func NewRabinkarpChecksum() *RabinkarpChecksum {
 	}
 }
 
+// rabinkarpPowers holds RabinkarpMultiplier to the powers 0 to 8.
+var rabinkarpPowers = func() (powers [9]uint32) {
+	powers[0] = 1
+	for i := 1; i < len(powers); i++ {
+		powers[i] = powers[i-1] * RabinkarpMultiplier
+	}
+
+	return powers
+}()
+
+// Update adds buf 8 bytes at a time, the hash is multiplied by M^8 and every byte by the power of M it would have
+// been multiplied by one byte at a time.
 func (r *RabinkarpChecksum) Update(buf []byte) {
 	length := len(buf)
 	r.count += uint64(length)
 
-	for i := 0; i < length; i++ {
-		r.hash = r.hash*RabinkarpMultiplier + uint32(buf[i])
-		r.multiplier *= RabinkarpMultiplier
+	p := &rabinkarpPowers
+	hash, multiplier := r.hash, r.multiplier
+	i := 0
+	for ; i+8 <= length; i += 8 {
+		b := buf[i : i+8 : i+8]
+		hash = hash*p[8] +
+			(uint32(b[0])*p[7] + uint32(b[1])*p[6] + uint32(b[2])*p[5] + uint32(b[3])*p[4]) +
+			(uint32(b[4])*p[3] + uint32(b[5])*p[2] + uint32(b[6])*p[1] + uint32(b[7]))
+		multiplier *= p[8]
+	}
+	for ; i < length; i++ {
+		hash = hash*RabinkarpMultiplier + uint32(buf[i])
+		multiplier *= RabinkarpMultiplier
 	}
+
+	r.hash, r.multiplier = hash, multiplier
 }
 
 func (r *RabinkarpChecksum) Rotate(out, in byte) {
@@ -48,3 +72,7 @@ func (r *RabinkarpChecksum) Reset() {
 	r.hash = RabinkarpSeed
 	r.multiplier = 1
 }
+
+func (r *RabinkarpChecksum) Count() uint64 {
+	return r.count
+}
@@ -0,0 +1,79 @@
+package rdiff
+
+// RabinkarpChecksum64 is the 64-bit variant of RabinkarpChecksum, it gives far fewer false weak matches on large
+// files.
+type RabinkarpChecksum64 struct {
+	count            uint64
+	hash, multiplier uint64
+}
+
+func NewRabinkarpChecksum64() *RabinkarpChecksum64 {
+	return &RabinkarpChecksum64{
+		count:      0,
+		hash:       Rabinkarp64Seed,
+		multiplier: 1,
+	}
+}
+
+// rabinkarp64Powers holds Rabinkarp64Multiplier to the powers 0 to 8.
+var rabinkarp64Powers = func() (powers [9]uint64) {
+	powers[0] = 1
+	for i := 1; i < len(powers); i++ {
+		powers[i] = powers[i-1] * Rabinkarp64Multiplier
+	}
+
+	return powers
+}()
+
+// Update adds buf 8 bytes at a time like RabinkarpChecksum.Update.
+func (r *RabinkarpChecksum64) Update(buf []byte) {
+	length := len(buf)
+	r.count += uint64(length)
+
+	p := &rabinkarp64Powers
+	hash, multiplier := r.hash, r.multiplier
+	i := 0
+	for ; i+8 <= length; i += 8 {
+		b := buf[i : i+8 : i+8]
+		hash = hash*p[8] +
+			(uint64(b[0])*p[7] + uint64(b[1])*p[6] + uint64(b[2])*p[5] + uint64(b[3])*p[4]) +
+			(uint64(b[4])*p[3] + uint64(b[5])*p[2] + uint64(b[6])*p[1] + uint64(b[7]))
+		multiplier *= p[8]
+	}
+	for ; i < length; i++ {
+		hash = hash*Rabinkarp64Multiplier + uint64(buf[i])
+		multiplier *= Rabinkarp64Multiplier
+	}
+
+	r.hash, r.multiplier = hash, multiplier
+}
+
+func (r *RabinkarpChecksum64) Rotate(out, in byte) {
+	r.hash = r.hash*Rabinkarp64Multiplier + uint64(in) - r.multiplier*(uint64(out)+Rabinkarp64Adjustment)
+}
+
+func (r *RabinkarpChecksum64) Rollin(in byte) {
+	r.hash = r.hash*Rabinkarp64Multiplier + uint64(in)
+	r.count++
+	r.multiplier *= Rabinkarp64Multiplier
+}
+
+func (r *RabinkarpChecksum64) Rollout(out byte) {
+	r.count--
+	r.multiplier *= Rabinkarp64MultiplierInverseModular
+	r.hash -= r.multiplier * (uint64(out) + Rabinkarp64Adjustment)
+}
+
+func (r *RabinkarpChecksum64) Digest() uint64 {
+	return r.hash
+}
+
+func (r *RabinkarpChecksum64) Count() uint64 {
+	return r.count
+}
+
+func (r *RabinkarpChecksum64) Reset() {
+	r.count = 0
+	r.hash = Rabinkarp64Seed
+	r.multiplier = 1
+}
@@ -0,0 +1,140 @@
+package rdiff
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRabinkarpChecksum64(t *testing.T) {
+	r := NewRabinkarpChecksum64()
+	assert.Equal(t, Rabinkarp64Seed, r.Digest())
+
+	r.Rollin(0)
+	assert.Equal(t, Rabinkarp64Multiplier, r.Digest())
+	r.Rollin(1)
+	r.Rollin(2)
+	r.Rollin(3)
+	assert.Equal(t, uint64(4), r.Count())
+
+	r.Rollout(0)
+	r.Rollout(1)
+	r.Rollout(2)
+	r.Rollout(3)
+	assert.Equal(t, Rabinkarp64Seed, r.Digest())
+	assert.Equal(t, uint64(0), r.Count())
+
+	data, err := generateBytes(1000)
+	assert.Nil(t, err)
+
+	// Rolling the window gives the checksum of the window
+	window := 100
+	r.Update(data[:window])
+	rotating := NewRabinkarpChecksum64()
+	rotating.Update(data[:window])
+	for i := window; i < len(data); i++ {
+		r.Rollin(data[i])
+		r.Rollout(data[i-window])
+		rotating.Rotate(data[i-window], data[i])
+
+		expected := NewRabinkarpChecksum64()
+		expected.Update(data[i-window+1 : i+1])
+		assert.Equal(t, expected.Digest(), r.Digest())
+		assert.Equal(t, expected.Digest(), rotating.Digest())
+	}
+
+	r.Reset()
+	assert.Equal(t, Rabinkarp64Seed, r.Digest())
+}
+
+// BenchmarkWeakChecksum_FalsePositives scans random data over the signature of other random data and reports the
+// number of weak checksum matches, every one of them is a false positive that costs a strong checksum.
+func BenchmarkWeakChecksum_FalsePositives(b *testing.B) {
+	const blockSize = 64
+
+	originalFile, err := generateBytes(4 << 20)
+	assert.Nil(b, err)
+	newFile, err := generateBytes(4 << 20)
+	assert.Nil(b, err)
+
+	for _, checksumType := range []ChecksumType{Rollsum_Blake2b, Rabinkarp_Blake2b, Rabinkarp64_Blake2b, Buzhash_Blake2b} {
+		b.Run(fmt.Sprintf("%#x", checksumType), func(b *testing.B) {
+			signatureBuffer := &bytes.Buffer{}
+			assert.Nil(b, WriteSignature(bytes.NewReader(originalFile), signatureBuffer, checksumType, blockSize, 8))
+			signature, err := ReadSignature(signatureBuffer)
+			assert.Nil(b, err)
+
+			b.SetBytes(int64(len(newFile)))
+			b.ResetTimer()
+
+			falsePositives := 0
+			for n := 0; n < b.N; n++ {
+				checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
+				assert.Nil(b, err)
+
+				for i, in := range newFile {
+					checksum.Rollin(in)
+					if i >= blockSize {
+						checksum.Rollout(newFile[i-blockSize])
+					}
+
+					if _, ok := signature.weakChecksums[checksum.Digest64()]; ok && checksum.Count() == blockSize {
+						falsePositives++
+					}
+				}
+			}
+
+			b.ReportMetric(float64(falsePositives)/float64(b.N), "false-positives/op")
+		})
+	}
+}
+
+// referenceRabinkarp64Update is the byte at a time RabinkarpChecksum64.Update the unrolled implementation is
+// checked against.
+func referenceRabinkarp64Update(r *RabinkarpChecksum64, buf []byte) {
+	length := len(buf)
+	r.count += uint64(length)
+
+	for i := 0; i < length; i++ {
+		r.hash = r.hash*Rabinkarp64Multiplier + uint64(buf[i])
+		r.multiplier *= Rabinkarp64Multiplier
+	}
+}
+
+func TestRabinkarpChecksum64_Update(t *testing.T) {
+	data, err := generateBytes(70000)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		begin := rand(0, len(data))
+		end := len(data)
+		if i%2 == 0 && begin+100 < end {
+			end = begin + 100
+		}
+		end = rand(begin, end)
+
+		expected := NewRabinkarpChecksum64()
+		actual := NewRabinkarpChecksum64()
+		for _, in := range data[:rand(0, 10)] {
+			expected.Rollin(in)
+			actual.Rollin(in)
+		}
+
+		referenceRabinkarp64Update(expected, data[begin:end])
+		actual.Update(data[begin:end])
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func BenchmarkRabinkarpChecksum64_Update(b *testing.B) {
+	data, err := generateBytes(64 << 10)
+	assert.Nil(b, err)
+
+	b.SetBytes(int64(len(data)))
+	r := NewRabinkarpChecksum64()
+	for n := 0; n < b.N; n++ {
+		r.Update(data)
+	}
+}
@@ -37,4 +37,53 @@ func TestRabinkarpChecksum(t *testing.T) {
 	}
 	r.Update(buf)
 	assert.Equal(t, uint32(0xc1972381), r.Digest())
+	assert.Equal(t, uint64(256), r.Count())
+}
+
+// referenceRabinkarpUpdate is the byte at a time RabinkarpChecksum.Update the unrolled implementation is checked
+// against.
+func referenceRabinkarpUpdate(r *RabinkarpChecksum, buf []byte) {
+	length := len(buf)
+	r.count += uint64(length)
+
+	for i := 0; i < length; i++ {
+		r.hash = r.hash*RabinkarpMultiplier + uint32(buf[i])
+		r.multiplier *= RabinkarpMultiplier
+	}
+}
+
+func TestRabinkarpChecksum_Update(t *testing.T) {
+	data, err := generateBytes(70000)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		begin := rand(0, len(data))
+		end := len(data)
+		if i%2 == 0 && begin+100 < end {
+			end = begin + 100
+		}
+		end = rand(begin, end)
+
+		expected := NewRabinkarpChecksum()
+		actual := NewRabinkarpChecksum()
+		for _, in := range data[:rand(0, 10)] {
+			expected.Rollin(in)
+			actual.Rollin(in)
+		}
+
+		referenceRabinkarpUpdate(expected, data[begin:end])
+		actual.Update(data[begin:end])
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func BenchmarkRabinkarpChecksum_Update(b *testing.B) {
+	data, err := generateBytes(64 << 10)
+	assert.Nil(b, err)
+
+	b.SetBytes(int64(len(data)))
+	r := NewRabinkarpChecksum()
+	for n := 0; n < b.N; n++ {
+		r.Update(data)
+	}
 }
@@ -0,0 +1,226 @@
+package rdiff
+
+import (
+	"bytes"
+	"io"
+)
+
+// DefaultCacheSize is the memory used for original file data when PatchOptions.CacheSize is not set.
+const DefaultCacheSize = 4 << 20
+
+// patchCommand is a command decoded by the patcher together with its place in the delta file.
+type patchCommand struct {
+	*DeltaCommand
+
+	// offset and index locate the command in the delta file, end is the position right after its literal data.
+	offset int64
+	index  int64
+	end    int64
+
+	// buffered is set when the literal data were read ahead into memory.
+	buffered bool
+}
+
+// stopsReadAhead reports whether no command can be decoded after c: the end command, or a literal whose data were
+// not read ahead and are still in the delta file.
+func (c *patchCommand) stopsReadAhead() bool {
+	return c.Type == End || (c.Type == Literal && !c.buffered)
+}
+
+// originalSource reads the copy ranges of the original file for the patcher.
+type originalSource interface {
+	// copyTo writes length bytes of the original file at position to out. upcoming lists the commands that follow.
+	copyTo(out io.Writer, position, length int64, upcoming []*patchCommand) error
+
+	// readBlock returns up to length bytes of the original file at position, less only at the end of the file.
+	readBlock(position, length int64) ([]byte, error)
+}
+
+// newSeekSource returns the source that reads copy ranges from originalFile, merging them when read-ahead is enabled.
+func newSeekSource(originalFile io.ReadSeeker, options *PatchOptions) originalSource {
+	if options.ReadAhead > 0 {
+		return &cachedSource{originalFile: originalFile, gap: options.ReadAheadGap, cache: spanCache{size: options.cacheSize()}}
+	}
+
+	return &seekSource{originalFile: originalFile}
+}
+
+// seekSource seeks and reads the original file once per copy command.
+type seekSource struct {
+	originalFile io.ReadSeeker
+}
+
+func (s *seekSource) copyTo(out io.Writer, position, length int64, upcoming []*patchCommand) error {
+	if _, err := s.originalFile.Seek(position, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(out, s.originalFile, length)
+	return err
+}
+
+func (s *seekSource) readBlock(position, length int64) ([]byte, error) {
+	return readBlock(s.originalFile, position, length)
+}
+
+type cacheSpan struct {
+	position int64
+	data     []byte
+}
+
+// spanCache keeps original file data up to size bytes, the oldest spans are evicted first.
+type spanCache struct {
+	size   int64
+	spans  []*cacheSpan
+	cached int64
+}
+
+// find returns the span holding the whole range, or nil.
+func (c *spanCache) find(position, length int64) *cacheSpan {
+	for _, span := range c.spans {
+		if position >= span.position && position+length <= span.position+int64(len(span.data)) {
+			return span
+		}
+	}
+
+	return nil
+}
+
+func (c *spanCache) add(span *cacheSpan) {
+	c.spans = append(c.spans, span)
+	c.cached += int64(len(span.data))
+	for c.cached > c.size {
+		c.cached -= int64(len(c.spans[0].data))
+		c.spans = c.spans[1:]
+	}
+}
+
+// write writes the range of span to out.
+func (s *cacheSpan) write(out io.Writer, position, length int64) error {
+	begin := position - s.position
+	_, err := out.Write(s.data[begin : begin+length])
+	return err
+}
+
+// cachedSource merges the ranges of upcoming copy commands that lie within gap bytes of each other into a single
+// read and caches the data read.
+type cachedSource struct {
+	originalFile io.ReadSeeker
+	gap          int64
+	cache        spanCache
+}
+
+func (s *cachedSource) copyTo(out io.Writer, position, length int64, upcoming []*patchCommand) error {
+	if length > s.cache.size {
+		return (&seekSource{s.originalFile}).copyTo(out, position, length, upcoming)
+	}
+
+	span := s.cache.find(position, length)
+	if span == nil {
+		var err error
+		if span, err = s.read(position, length, upcoming); err != nil {
+			return err
+		}
+	}
+
+	return span.write(out, position, length)
+}
+
+func (s *cachedSource) readBlock(position, length int64) ([]byte, error) {
+	if span := s.cache.find(position, length); span != nil {
+		return span.data[position-span.position : position-span.position+length], nil
+	}
+
+	return readBlock(s.originalFile, position, length)
+}
+
+// read reads the range of a copy command extended over the nearby ranges of the upcoming copy commands.
+func (s *cachedSource) read(position, length int64, upcoming []*patchCommand) (*cacheSpan, error) {
+	end := position + length
+	for _, command := range upcoming {
+		if command.Type != Copy {
+			continue
+		}
+
+		commandPosition := int64(command.Position)
+		commandEnd := commandPosition + int64(command.Length)
+		if commandPosition >= position && commandPosition <= end+s.gap && commandEnd > end && commandEnd-position <= s.cache.size {
+			end = commandEnd
+		}
+	}
+
+	if _, err := s.originalFile.Seek(position, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, end-position)
+	n, err := io.ReadFull(s.originalFile, data)
+	if int64(n) < length {
+		return nil, noEOF(err)
+	}
+
+	// A merged range past the end of the original file is kept up to the end, the copy reading it fails later.
+	span := &cacheSpan{position: position, data: data[:n]}
+	s.cache.add(span)
+
+	return span, nil
+}
+
+// next returns the next command, decoding up to ReadAhead commands ahead of it.
+func (p *patcher) next() (*patchCommand, error) {
+	p.fill()
+
+	if len(p.queue) == 0 {
+		return nil, p.err
+	}
+
+	command := p.queue[0]
+	p.queue = p.queue[1:]
+	if command.buffered {
+		p.queued -= int64(command.Length)
+	}
+
+	return command, nil
+}
+
+// fill decodes commands until the queue holds ReadAhead commands after its head. Literal data are read ahead into
+// memory as long as the queue holds less than CacheSize bytes of them. A decoding error is kept until the commands
+// before it are processed.
+func (p *patcher) fill() {
+	for p.err == nil && len(p.queue) <= p.options.ReadAhead {
+		if len(p.queue) > 0 && p.queue[len(p.queue)-1].stopsReadAhead() {
+			return
+		}
+
+		deltaCommand, err := p.reader.Next()
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		command := &patchCommand{DeltaCommand: deltaCommand, offset: p.reader.Offset(), index: p.reader.Index(), end: p.reader.in.count}
+		if command.Type == Literal {
+			command.end += int64(command.Length)
+
+			if len(p.queue) > 0 && p.queued+int64(command.Length) <= p.options.cacheSize() {
+				data := make([]byte, command.Length)
+				if _, err = io.ReadFull(command.Data, data); err != nil {
+					p.err = noEOF(err)
+					return
+				}
+				command.Data = bytes.NewReader(data)
+				command.buffered = true
+				p.queued += int64(command.Length)
+			}
+		}
+		p.queue = append(p.queue, command)
+	}
+}
+
+func (o *PatchOptions) cacheSize() int64 {
+	if o.CacheSize > 0 {
+		return o.CacheSize
+	}
+
+	return DefaultCacheSize
+}
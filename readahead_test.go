@@ -0,0 +1,122 @@
+package rdiff
+
+import (
+	"bytes"
+	cryptoRand "crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingReadSeeker counts the seeks, each of them starts a random read of the original file.
+type countingReadSeeker struct {
+	io.ReadSeeker
+	seeks int
+}
+
+func (r *countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	r.seeks++
+
+	return r.ReadSeeker.Seek(offset, whence)
+}
+
+// generateSparseChanges modifies a random byte in every changeInterval bytes of the original file.
+func generateSparseChanges(originalFile []byte, changeInterval int) []byte {
+	newFile := make([]byte, 0)
+	newFile = append(newFile, originalFile...)
+	for i := rand(0, changeInterval); i < len(newFile); i += changeInterval {
+		newFile[i]++
+	}
+
+	return newFile
+}
+
+func TestPatchWithOptions_ReadAhead(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(10, 100)
+		assert.Nil(t, err)
+
+		// Modify and move some blocks
+		newFile := generateSparseChanges(originalFile, int(blockSize)*5)
+		blockIndex := rand64(0, int(blockNumber)-2)
+		newFile = append(newFile, originalFile[blockIndex*blockSize:(blockIndex+1)*blockSize]...)
+		_, err = cryptoRand.Read(newFile[:blockSize/2])
+		assert.Nil(t, err)
+
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		testCases := []*PatchOptions{
+			{ReadAhead: 1},
+			{ReadAhead: 64, ReadAheadGap: int64(blockSize)},
+			// Cache smaller than a block, every copy bypasses the cache
+			{ReadAhead: 64, CacheSize: int64(blockSize - 1)},
+			// Cache of a few blocks, cached ranges are evicted
+			{ReadAhead: 64, ReadAheadGap: int64(blockSize * 4), CacheSize: int64(blockSize * 3)},
+		}
+
+		for _, options := range testCases {
+			actualNewFile := &bytes.Buffer{}
+			err = PatchWithOptions(bytes.NewReader(originalFile), actualNewFile, bytes.NewReader(delta.Bytes()), options)
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, actualNewFile.Bytes())
+		}
+	}
+}
+
+func TestPatchWithOptions_ReadAheadMergesReads(t *testing.T) {
+	originalFile, err := generateBytes(100000)
+	assert.Nil(t, err)
+
+	// Nearby copy ranges separated by literals
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	for position := uint64(0); position < 10000; position += 1000 {
+		assert.Nil(t, writer.WriteCopy(position, 900))
+		assert.Nil(t, writer.WriteLiteral([]byte{1, 2, 3}))
+	}
+	assert.Nil(t, writer.WriteCopy(50000, 100))
+	assert.Nil(t, writer.Close())
+
+	originalReader := &countingReadSeeker{ReadSeeker: bytes.NewReader(originalFile)}
+	options := &PatchOptions{ReadAhead: 100, ReadAheadGap: 100}
+	err = PatchWithOptions(originalReader, &bytes.Buffer{}, delta, options)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, originalReader.seeks)
+}
+
+func BenchmarkPatch_SparseChanges(b *testing.B) {
+	originalFile, err := generateBytes(16 << 20)
+	assert.Nil(b, err)
+	newFile := generateSparseChanges(originalFile, 64<<10)
+	delta, err := generateDelta(originalFile, newFile, 1024, Rabinkarp_Blake2b, 16, 1<<20)
+	assert.Nil(b, err)
+
+	benchmarks := []struct {
+		name    string
+		options *PatchOptions
+	}{
+		{"NoReadAhead", nil},
+		{"ReadAhead", &PatchOptions{ReadAhead: 256, ReadAheadGap: 4096}},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.SetBytes(int64(len(newFile)))
+			seeks := 0
+			for i := 0; i < b.N; i++ {
+				originalReader := &countingReadSeeker{ReadSeeker: bytes.NewReader(originalFile)}
+				newFileBuffer := bytes.NewBuffer(make([]byte, 0, len(newFile)))
+				if err := PatchWithOptions(originalReader, newFileBuffer, bytes.NewReader(delta.Bytes()), benchmark.options); err != nil {
+					b.Fatal(err)
+				}
+				seeks += originalReader.seeks
+			}
+			b.ReportMetric(float64(seeks)/float64(b.N), "reads/op")
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package rdiff
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/md4"
+)
+
+// RollingHash is the weak checksum of a block that is updated as the block window slides over the input.
+// RabinkarpChecksum64 and BuzhashChecksum implement it.
+type RollingHash interface {
+	// Update adds buf at the end of the window.
+	Update(buf []byte)
+
+	// Rollin adds a byte at the end of the window.
+	Rollin(in byte)
+
+	// Rollout removes a byte from the start of the window.
+	Rollout(out byte)
+
+	// Rotate removes out from the start of the window and adds in at its end.
+	Rotate(out, in byte)
+
+	// Digest returns the weak checksum, signatures store its WeakSize low bytes.
+	Digest() uint64
+
+	// Count returns the number of bytes in the window.
+	Count() uint64
+
+	Reset()
+}
+
+// StrongHash is the strong checksum of a block. Every hash.Hash implements it.
+type StrongHash interface {
+	Write(p []byte) (int, error)
+	Sum(b []byte) []byte
+	Reset()
+	Size() int
+}
+
+// ChecksumAlgorithm is the pair of weak and strong checksums a ChecksumType stands for.
+type ChecksumAlgorithm struct {
+	NewRollingHash func() RollingHash
+
+	// NewStrongHash returns the strong hash keyed with key, which is nil when KeySize is 0.
+	NewStrongHash func(key []byte) StrongHash
+
+	// KeySize is the size of the random key WriteSignature generates and stores in the signature header, 0 for
+	// strong hashes without a key.
+	KeySize uint32
+
+	// WeakSize is the size in bytes of the weak checksums in the signature, 4 if 0.
+	WeakSize uint32
+}
+
+var (
+	checksumAlgorithmsMutex sync.RWMutex
+	checksumAlgorithms      = map[ChecksumType]*ChecksumAlgorithm{
+		Rollsum_Md4:       {NewRollingHash: newRollsum, NewStrongHash: newMd4},
+		Rollsum_Blake2b:   {NewRollingHash: newRollsum, NewStrongHash: newBlake2b},
+		Rabinkarp_Md4:     {NewRollingHash: newRabinkarp, NewStrongHash: newMd4},
+		Rabinkarp_Blake2b: {NewRollingHash: newRabinkarp, NewStrongHash: newBlake2b},
+		Rollsum_Sha256:    {NewRollingHash: newRollsum, NewStrongHash: newSha256},
+		Rabinkarp_Sha256:  {NewRollingHash: newRabinkarp, NewStrongHash: newSha256},
+
+		Rollsum_Blake2b_Keyed:   {NewRollingHash: newRollsum, NewStrongHash: newBlake2b, KeySize: Blake2bKeySize},
+		Rabinkarp_Blake2b_Keyed: {NewRollingHash: newRabinkarp, NewStrongHash: newBlake2b, KeySize: Blake2bKeySize},
+
+		Rabinkarp64_Blake2b: {NewRollingHash: newRabinkarp64, NewStrongHash: newBlake2b, WeakSize: 8},
+
+		Buzhash_Md4:     {NewRollingHash: newBuzhash, NewStrongHash: newMd4, WeakSize: 8},
+		Buzhash_Blake2b: {NewRollingHash: newBuzhash, NewStrongHash: newBlake2b, WeakSize: 8},
+	}
+)
+
+// RegisterChecksumAlgorithm makes algorithm available under checksumType to WriteSignature, ReadSignature,
+// WriteDelta and Patch. It panics if checksumType is already registered or if algorithm is incomplete.
+func RegisterChecksumAlgorithm(checksumType ChecksumType, algorithm ChecksumAlgorithm) {
+	if algorithm.NewRollingHash == nil || algorithm.NewStrongHash == nil {
+		panic(fmt.Sprintf("rdiff: incomplete checksum algorithm for checksum type %#x", checksumType))
+	}
+	if algorithm.WeakSize != 0 && algorithm.WeakSize != 4 && algorithm.WeakSize != 8 {
+		panic(fmt.Sprintf("rdiff: weak checksum size %d of checksum type %#x is not 4 or 8", algorithm.WeakSize, checksumType))
+	}
+
+	checksumAlgorithmsMutex.Lock()
+	defer checksumAlgorithmsMutex.Unlock()
+
+	if _, ok := checksumAlgorithms[checksumType]; ok {
+		panic(fmt.Sprintf("rdiff: checksum type %#x registered twice", checksumType))
+	}
+	checksumAlgorithms[checksumType] = &algorithm
+}
+
+// LookupChecksumAlgorithm returns the algorithm registered under checksumType.
+func LookupChecksumAlgorithm(checksumType ChecksumType) (*ChecksumAlgorithm, error) {
+	checksumAlgorithmsMutex.RLock()
+	defer checksumAlgorithmsMutex.RUnlock()
+
+	algorithm, ok := checksumAlgorithms[checksumType]
+	if !ok {
+		return nil, fmt.Errorf("%w %#x", ErrInvalidChecksumType, checksumType)
+	}
+
+	return algorithm, nil
+}
+
+// rollsumHash and rabinkarpHash adapt the 32-bit digest of RollingChecksum and RabinkarpChecksum to RollingHash.
+// The checksums are embedded by value so that their methods are called directly.
+type rollsumHash struct {
+	RollingChecksum
+}
+
+func (h *rollsumHash) Digest() uint64 {
+	return uint64(h.RollingChecksum.Digest())
+}
+
+type rabinkarpHash struct {
+	RabinkarpChecksum
+}
+
+func (h *rabinkarpHash) Digest() uint64 {
+	return uint64(h.RabinkarpChecksum.Digest())
+}
+
+func newRollsum() RollingHash {
+	return &rollsumHash{*NewRollingChecksum()}
+}
+
+func newRabinkarp() RollingHash {
+	return &rabinkarpHash{*NewRabinkarpChecksum()}
+}
+
+func newRabinkarp64() RollingHash {
+	return NewRabinkarpChecksum64()
+}
+
+func newBuzhash() RollingHash {
+	return NewBuzhashChecksum()
+}
+
+func newMd4(key []byte) StrongHash {
+	return md4.New()
+}
+
+func newBlake2b(key []byte) StrongHash {
+	// New256 only fails for keys longer than 64 bytes.
+	h, _ := blake2b.New256(key)
+	return h
+}
+
+func newSha256(key []byte) StrongHash {
+	return sha256.New()
+}
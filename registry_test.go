@@ -0,0 +1,137 @@
+package rdiff
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// byteSum is a plain sum of the window bytes, good enough to test a private algorithm.
+type byteSum struct {
+	count uint64
+	sum   uint32
+}
+
+func (s *byteSum) Update(buf []byte) {
+	for _, b := range buf {
+		s.Rollin(b)
+	}
+}
+
+func (s *byteSum) Rollin(in byte) {
+	s.sum += uint32(in)
+	s.count++
+}
+
+func (s *byteSum) Rollout(out byte) {
+	s.sum -= uint32(out)
+	s.count--
+}
+
+func (s *byteSum) Rotate(out, in byte) {
+	s.sum += uint32(in) - uint32(out)
+}
+
+func (s *byteSum) Digest() uint64 {
+	return uint64(s.sum)
+}
+
+func (s *byteSum) Count() uint64 {
+	return s.count
+}
+
+func (s *byteSum) Reset() {
+	*s = byteSum{}
+}
+
+const byteSumSha1 ChecksumType = 0x7f000001
+
+func init() {
+	RegisterChecksumAlgorithm(byteSumSha1, ChecksumAlgorithm{
+		NewRollingHash: func() RollingHash { return &byteSum{} },
+		NewStrongHash:  func(key []byte) StrongHash { return sha1.New() },
+	})
+}
+
+func TestRegisterChecksumAlgorithm(t *testing.T) {
+	// Generate file
+	_, blockSize, _, originalFile, err := generateFile(10, 100)
+	assert.Nil(t, err)
+	newFile := generateSparseChanges(originalFile, int(blockSize)*3)
+
+	checksum, err := NewChecksum(byteSumSha1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(sha1.Size), checksum.MaxStrongChecksumSize())
+
+	signature := &bytes.Buffer{}
+	assert.Nil(t, WriteSignature(bytes.NewReader(originalFile), signature, byteSumSha1, uint32(blockSize), sha1.Size))
+	assert.NotNil(t, WriteSignature(bytes.NewReader(originalFile), &bytes.Buffer{}, byteSumSha1, uint32(blockSize), sha1.Size+1))
+
+	delta, err := generateDelta(originalFile, newFile, uint32(blockSize), byteSumSha1, 16, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	stats, err := EstimateDelta(readTestSignature(t, originalFile, byteSumSha1, uint32(blockSize)), bytes.NewReader(newFile), uint32(blockSize*2))
+	assert.Nil(t, err)
+	assert.Positive(t, stats.CopyCommands)
+
+	actualNewFile := &bytes.Buffer{}
+	assert.Nil(t, Patch(bytes.NewReader(originalFile), actualNewFile, delta))
+	assert.Equal(t, newFile, actualNewFile.Bytes())
+}
+
+func TestRegisterChecksumAlgorithm_Invalid(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterChecksumAlgorithm(Rollsum_Md4, ChecksumAlgorithm{NewRollingHash: newRollsum, NewStrongHash: newMd4})
+	})
+	assert.Panics(t, func() {
+		RegisterChecksumAlgorithm(0x7f000002, ChecksumAlgorithm{NewRollingHash: newRollsum})
+	})
+
+	_, err := LookupChecksumAlgorithm(0x7f000002)
+	assert.True(t, errors.Is(err, ErrInvalidChecksumType))
+}
+
+func TestChecksum_WeakChecksum(t *testing.T) {
+	data, err := generateBytes(1000)
+	assert.Nil(t, err)
+
+	for _, checksumType := range ChecksumTypes {
+		checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
+		assert.Nil(t, err)
+
+		for _, in := range data {
+			checksum.Rollin(in)
+		}
+		weakChecksum := checksum.CalculateWeakChecksum64(data)
+		assert.Equal(t, weakChecksum, checksum.Digest64())
+		assert.Equal(t, uint32(weakChecksum), checksum.CalculateWeakChecksum(data))
+		assert.Equal(t, uint32(weakChecksum), checksum.Digest())
+		if checksum.WeakChecksumSize() == 4 {
+			assert.Equal(t, uint64(uint32(weakChecksum)), weakChecksum)
+		}
+	}
+
+	// The 32-bit weak checksums are those of the rolling checksums
+	checksum, err := NewChecksum(Rollsum_Md4)
+	assert.Nil(t, err)
+	rollsum := NewRollingChecksum()
+	rollsum.Update(data)
+	assert.Equal(t, rollsum.Digest(), checksum.CalculateWeakChecksum(data))
+}
+
+func TestChecksum_Sha256(t *testing.T) {
+	expected, _ := hex.DecodeString("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+	for _, checksumType := range []ChecksumType{Rollsum_Sha256, Rabinkarp_Sha256} {
+		checksum, err := NewKeyedChecksum(checksumType, testKey(checksumType))
+		assert.Nil(t, err)
+		assert.Equal(t, Sha256ChecksumMaxSize, checksum.MaxStrongChecksumSize())
+
+		strongChecksum, err := checksum.CalculateStrongChecksum([]byte("abc"), 32)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, strongChecksum)
+	}
+}
@@ -0,0 +1,161 @@
+package rdiff
+
+import (
+	"io"
+	"sort"
+)
+
+// reverseCopy maps a range of the original file to the place where a copy command put it in the new file.
+type reverseCopy struct {
+	originalPosition uint64
+	newPosition      uint64
+	length           uint64
+}
+
+type reverseDeltaWriter struct {
+	writer         *DeltaWriter
+	originalFile   io.ReadSeeker
+	maxLiteralSize uint32
+	copyCommand    *Command
+}
+
+func (w *reverseDeltaWriter) writeCopy(position, length uint64) error {
+	if w.copyCommand != nil && w.copyCommand.position+w.copyCommand.length == position {
+		w.copyCommand.length += length
+		return nil
+	}
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.copyCommand = &Command{commandType: Copy, position: position, length: length}
+
+	return nil
+}
+
+func (w *reverseDeltaWriter) writeLiteral(begin, end uint64) error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	if _, err := w.originalFile.Seek(int64(begin), io.SeekStart); err != nil {
+		return err
+	}
+
+	for position := begin; position < end; position += uint64(w.maxLiteralSize) {
+		length := end - position
+		if length > uint64(w.maxLiteralSize) {
+			length = uint64(w.maxLiteralSize)
+		}
+
+		if err := w.writer.WriteLiteralFrom(w.originalFile, length); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *reverseDeltaWriter) flush() error {
+	if w.copyCommand == nil {
+		return nil
+	}
+
+	err := w.writer.WriteCopy(w.copyCommand.position, w.copyCommand.length)
+	w.copyCommand = nil
+
+	return err
+}
+
+// PatchWithReverseDelta applies delta to originalFile like Patch does and writes to reverseDelta a delta that
+// turns the new file back into the original one. Ranges of the original file reused by the new file become copy
+// commands, the rest of the original file is written as literal commands of at most maxLiteralSize bytes.
+func PatchWithReverseDelta(originalFile io.ReadSeeker, newFile io.Writer, delta io.Reader, reverseDelta io.Writer, maxLiteralSize uint32) error {
+	if maxLiteralSize == 0 {
+		return ErrInvalidMaxLiteralSize
+	}
+
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	var copies []reverseCopy
+	newPosition := uint64(0)
+	for end := false; !end; {
+		command, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		switch command.Type {
+		case End:
+			end = true
+		case Literal:
+			if _, err = io.CopyN(newFile, command.Data, int64(command.Length)); err != nil {
+				return err
+			}
+		case Copy:
+			if _, err = originalFile.Seek(int64(command.Position), io.SeekStart); err != nil {
+				return err
+			}
+
+			if _, err = io.CopyN(newFile, originalFile, int64(command.Length)); err != nil {
+				return err
+			}
+
+			copies = append(copies, reverseCopy{command.Position, newPosition, command.Length})
+		}
+		newPosition += command.Length
+	}
+
+	originalSize, err := originalFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(copies, func(i, j int) bool {
+		return copies[i].originalPosition < copies[j].originalPosition
+	})
+
+	deltaWriter, err := NewDeltaWriter(reverseDelta)
+	if err != nil {
+		return err
+	}
+
+	writer := &reverseDeltaWriter{writer: deltaWriter, originalFile: originalFile, maxLiteralSize: maxLiteralSize}
+	position := uint64(0)
+	for _, c := range copies {
+		end := c.originalPosition + c.length
+		if end > uint64(originalSize) {
+			end = uint64(originalSize)
+		}
+		if end <= position {
+			continue
+		}
+
+		if c.originalPosition > position {
+			if err = writer.writeLiteral(position, c.originalPosition); err != nil {
+				return err
+			}
+			position = c.originalPosition
+		}
+
+		if err = writer.writeCopy(c.newPosition+position-c.originalPosition, end-position); err != nil {
+			return err
+		}
+		position = end
+	}
+
+	if position < uint64(originalSize) {
+		if err = writer.writeLiteral(position, uint64(originalSize)); err != nil {
+			return err
+		}
+	}
+
+	if err = writer.flush(); err != nil {
+		return err
+	}
+
+	return deltaWriter.Close()
+}
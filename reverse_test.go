@@ -0,0 +1,121 @@
+package rdiff
+
+import (
+	"bytes"
+	cryptoRand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchWithReverseDelta_ModifyBlock(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(2, 100)
+		assert.Nil(t, err)
+
+		// Modify one of the blocks
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile...)
+		blockIndex := rand64(0, int(blockNumber)-2)
+		blockBegin := blockIndex * blockSize
+		blockEnd := blockBegin + blockSize
+		_, err = cryptoRand.Read(newFile[blockBegin:blockEnd])
+		assert.Nil(t, err)
+
+		// Calculate delta
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		// Apply patch
+		actualNewFile := &bytes.Buffer{}
+		reverseDelta := &bytes.Buffer{}
+		err = PatchWithReverseDelta(bytes.NewReader(originalFile), actualNewFile, delta, reverseDelta, uint32(blockSize))
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, actualNewFile.Bytes())
+
+		// Roll back
+		actualOriginalFile := &bytes.Buffer{}
+		err = Patch(bytes.NewReader(newFile), actualOriginalFile, reverseDelta)
+		assert.Nil(t, err)
+		assert.Equal(t, originalFile, actualOriginalFile.Bytes())
+	}
+}
+
+func TestPatchWithReverseDelta_RemoveAndPrepend(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(3, 100)
+		assert.Nil(t, err)
+
+		// Remove one of the blocks and insert random bytes at the beginning of the file
+		blockIndex := rand64(0, int(blockNumber)-2)
+		blockBegin := blockIndex * blockSize
+		blockEnd := blockBegin + blockSize
+		insertData, err := generateBytes(rand64(1, 100))
+		assert.Nil(t, err)
+		newFile := make([]byte, 0)
+		newFile = append(newFile, insertData...)
+		newFile = append(newFile, originalFile[:blockBegin]...)
+		newFile = append(newFile, originalFile[blockEnd:]...)
+
+		// Calculate delta
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		// Apply patch, literals of the reverse delta are split
+		actualNewFile := &bytes.Buffer{}
+		reverseDelta := &bytes.Buffer{}
+		err = PatchWithReverseDelta(bytes.NewReader(originalFile), actualNewFile, delta, reverseDelta, uint32(blockSize/3))
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, actualNewFile.Bytes())
+
+		// Roll back
+		actualOriginalFile := &bytes.Buffer{}
+		err = Patch(bytes.NewReader(newFile), actualOriginalFile, reverseDelta)
+		assert.Nil(t, err)
+		assert.Equal(t, originalFile, actualOriginalFile.Bytes())
+	}
+}
+
+func TestPatchWithReverseDelta_MergeCopies(t *testing.T) {
+	// Generate file
+	_, blockSize, _, originalFile, err := generateFile(2, 100)
+	assert.Nil(t, err)
+
+	// Calculate delta
+	delta, err := generateDelta(originalFile, originalFile, uint32(blockSize), Rabinkarp_Blake2b, 16, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	// Apply patch
+	reverseDelta := &bytes.Buffer{}
+	err = PatchWithReverseDelta(bytes.NewReader(originalFile), &bytes.Buffer{}, delta, reverseDelta, uint32(blockSize*2))
+	assert.Nil(t, err)
+
+	// All copied blocks are merged into a single copy command, the last block is a literal
+	fullBlocksLength := uint64(len(originalFile)) / blockSize * blockSize
+	lastBlock := originalFile[fullBlocksLength:]
+	expectedDelta := &bytes.Buffer{}
+	expectedDelta.Write([]byte{0x72, 0x73, 0x02, 0x36})
+	err = writeCommand(expectedDelta, &Command{commandType: Copy, position: 0, length: fullBlocksLength})
+	assert.Nil(t, err)
+	err = writeCommand(expectedDelta, &Command{commandType: Literal, length: uint64(len(lastBlock)), literalData: lastBlock})
+	assert.Nil(t, err)
+	expectedDelta.WriteByte(0)
+
+	assert.Equal(t, expectedDelta, reverseDelta)
+}
+
+func TestPatchWithReverseDelta_ZeroMaxLiteralSize(t *testing.T) {
+	originalFile, err := generateBytes(1000)
+	assert.Nil(t, err)
+
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(500, 100))
+	assert.Nil(t, writer.Close())
+
+	err = PatchWithReverseDelta(bytes.NewReader(originalFile), &bytes.Buffer{}, delta, &bytes.Buffer{}, 0)
+	assert.Equal(t, ErrInvalidMaxLiteralSize, err)
+}
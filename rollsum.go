@@ -9,14 +9,29 @@ func NewRollingChecksum() *RollingChecksum {
 	return &RollingChecksum{}
 }
 
+// Update adds buf 8 bytes at a time: s1 grows by the sum of the bytes and s2 by 8 times the previous s1 plus the
+// bytes weighted by the number of sums they are part of. The sums are taken modulo 2^32 and truncated to 16 bits
+// at the end, which gives the same result as 16-bit sums.
 func (r *RollingChecksum) Update(buf []byte) {
 	length := len(buf)
+	s1, s2 := uint32(r.s1), uint32(r.s2)
 
-	for i := 0; i < length; i++ {
-		r.s1 += uint16(buf[i])
-		r.s2 += r.s1
+	i := 0
+	for ; i+8 <= length; i += 8 {
+		b := buf[i : i+8 : i+8]
+		b0, b1, b2, b3 := uint32(b[0]), uint32(b[1]), uint32(b[2]), uint32(b[3])
+		b4, b5, b6, b7 := uint32(b[4]), uint32(b[5]), uint32(b[6]), uint32(b[7])
+
+		s2 += 8*s1 + (8*b0 + 7*b1 + 6*b2 + 5*b3) + (4*b4 + 3*b5 + 2*b6 + b7)
+		s1 += (b0 + b1 + b2 + b3) + (b4 + b5 + b6 + b7)
+	}
+	for ; i < length; i++ {
+		s1 += uint32(buf[i])
+		s2 += s1
 	}
 
+	r.s1, r.s2 = uint16(s1), uint16(s2)
+
 	r.s1 += uint16(length) * RollingChecksumCharOffset
 	r.s2 += uint16((length*(length+1))/2) * RollingChecksumCharOffset
 	r.count += uint64(length)
@@ -48,3 +63,7 @@ func (r *RollingChecksum) Reset() {
 	r.s1 = 0
 	r.s2 = 0
 }
+
+func (r *RollingChecksum) Count() uint64 {
+	return r.count
+}
@@ -37,4 +37,55 @@ func TestRollingChecksum(t *testing.T) {
 	}
 	r.Update(buf)
 	assert.Equal(t, uint32(0x3a009e80), r.Digest())
+	assert.Equal(t, uint64(256), r.Count())
+}
+
+// referenceRollingUpdate is the byte at a time RollingChecksum.Update the unrolled implementation is checked against.
+func referenceRollingUpdate(r *RollingChecksum, buf []byte) {
+	length := len(buf)
+
+	for i := 0; i < length; i++ {
+		r.s1 += uint16(buf[i])
+		r.s2 += r.s1
+	}
+
+	r.s1 += uint16(length) * RollingChecksumCharOffset
+	r.s2 += uint16((length*(length+1))/2) * RollingChecksumCharOffset
+	r.count += uint64(length)
+}
+
+func TestRollingChecksum_Update(t *testing.T) {
+	data, err := generateBytes(70000)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		begin := rand(0, len(data))
+		end := len(data)
+		if i%2 == 0 && begin+100 < end {
+			end = begin + 100
+		}
+		end = rand(begin, end)
+
+		expected := NewRollingChecksum()
+		actual := NewRollingChecksum()
+		for _, in := range data[:rand(0, 10)] {
+			expected.Rollin(in)
+			actual.Rollin(in)
+		}
+
+		referenceRollingUpdate(expected, data[begin:end])
+		actual.Update(data[begin:end])
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func BenchmarkRollingChecksum_Update(b *testing.B) {
+	data, err := generateBytes(64 << 10)
+	assert.Nil(b, err)
+
+	b.SetBytes(int64(len(data)))
+	r := NewRollingChecksum()
+	for n := 0; n < b.N; n++ {
+		r.Update(data)
+	}
 }
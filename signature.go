@@ -1,6 +1,8 @@
 package rdiff
 
 import (
+	"bytes"
+	cryptoRand "crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -13,19 +15,45 @@ type Signature struct {
 	checksumType       ChecksumType
 	strongChecksumSize uint32
 
-	weakChecksums   map[uint32]int
-	strongChecksums [][]byte
+	// key is the random key of keyed checksum types, stored after the header.
+	key []byte
+
+	// weakChecksums maps a weak checksum to the first block that has it, sameWeakChecksum links every block with
+	// the same weak checksum to the next one in index order. sameWeakChecksum is nil without such blocks.
+	weakChecksums    map[uint64]int
+	sameWeakChecksum map[int]int
+	strongChecksums  [][]byte
 }
 
+// WriteSignature writes the signature of in to out. Keyed checksum types get a new random key that is written
+// after the header.
 func WriteSignature(in io.Reader, out io.Writer, checksumType ChecksumType, blockSize uint32, strongChecksumSize uint32) error {
-	checksum, err := NewChecksum(checksumType)
+	algorithm, err := LookupChecksumAlgorithm(checksumType)
+	if err != nil {
+		return err
+	}
+
+	var key []byte
+	if algorithm.KeySize > 0 {
+		key = make([]byte, algorithm.KeySize)
+		if _, err = cryptoRand.Read(key); err != nil {
+			return err
+		}
+	}
+
+	return writeSignature(in, out, checksumType, blockSize, strongChecksumSize, key)
+}
+
+// writeSignature writes the signature of in to out with the strong checksums keyed with key.
+func writeSignature(in io.Reader, out io.Writer, checksumType ChecksumType, blockSize uint32, strongChecksumSize uint32, key []byte) error {
+	checksum, err := NewKeyedChecksum(checksumType, key)
 	if err != nil {
 		return err
 	}
 
 	maxStrongChecksumSize := checksum.MaxStrongChecksumSize()
 	if strongChecksumSize > maxStrongChecksumSize {
-		return fmt.Errorf("strong checksum size %d exceeds max allowed value %d for checksum type %#x", strongChecksumSize, maxStrongChecksumSize, checksumType)
+		return fmt.Errorf("%w: %d exceeds max allowed value %d for checksum type %#x", ErrInvalidStrongChecksumSize, strongChecksumSize, maxStrongChecksumSize, checksumType)
 	}
 
 	if err := binary.Write(out, binary.BigEndian, checksumType); err != nil {
@@ -40,9 +68,14 @@ func WriteSignature(in io.Reader, out io.Writer, checksumType ChecksumType, bloc
 		return err
 	}
 
+	if _, err := out.Write(key); err != nil {
+		return err
+	}
+
+	buffer := make([]byte, blockSize)
 	blockIndex := 0
 	for {
-		block := make([]byte, blockSize)
+		block := buffer
 		byteCount, err := io.ReadFull(in, block)
 		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			return err
@@ -54,12 +87,12 @@ func WriteSignature(in io.Reader, out io.Writer, checksumType ChecksumType, bloc
 
 		block = block[:byteCount]
 
-		weakChecksum := checksum.CalculateWeakChecksum(block)
-		if err = binary.Write(out, binary.BigEndian, weakChecksum); err != nil {
+		weakChecksum := checksum.CalculateWeakChecksum64(block)
+		if err = writeWeakChecksum(out, weakChecksum, checksum.WeakChecksumSize()); err != nil {
 			return err
 		}
 
-		strongChecksum, err := checksum.CalculateStrongChecksum(block, strongChecksumSize)
+		strongChecksum, err := checksum.strongChecksum(block, strongChecksumSize)
 		if err != nil {
 			return err
 		}
@@ -74,49 +107,124 @@ func WriteSignature(in io.Reader, out io.Writer, checksumType ChecksumType, bloc
 	return nil
 }
 
+// ReadSignature reads a signature file. Problems in the signature file are reported as *SignatureError.
 func ReadSignature(input io.Reader) (*Signature, error) {
-	var checksumType ChecksumType
-	if err := binary.Read(input, binary.BigEndian, &checksumType); err != nil {
-		return nil, err
+	var header struct {
+		ChecksumType       ChecksumType
+		BlockSize          uint32
+		StrongChecksumSize uint32
+	}
+	if err := binary.Read(input, binary.BigEndian, &header); err != nil {
+		return nil, signatureError(err, 0, -1)
+	}
+
+	algorithm, err := LookupChecksumAlgorithm(header.ChecksumType)
+	if err != nil {
+		return nil, signatureError(err, 0, -1)
+	}
+
+	var key []byte
+	if algorithm.KeySize > 0 {
+		key = make([]byte, algorithm.KeySize)
+		if _, err = io.ReadFull(input, key); err != nil {
+			return nil, signatureError(noEOF(err), 0, -1)
+		}
 	}
 
-	var blockSize uint32
-	if err := binary.Read(input, binary.BigEndian, &blockSize); err != nil {
-		return nil, err
+	checksum, err := NewKeyedChecksum(header.ChecksumType, key)
+	if err != nil {
+		return nil, signatureError(err, 0, -1)
 	}
 
-	var strongChecksumSize uint32
-	if err := binary.Read(input, binary.BigEndian, &strongChecksumSize); err != nil {
-		return nil, err
+	if header.StrongChecksumSize > checksum.MaxStrongChecksumSize() {
+		err = fmt.Errorf("%w: %d exceeds max allowed value %d for checksum type %#x", ErrInvalidStrongChecksumSize, header.StrongChecksumSize, checksum.MaxStrongChecksumSize(), header.ChecksumType)
+		return nil, signatureError(err, 0, -1)
 	}
 
-	weakChecksums := make(map[uint32]int)
+	weakChecksums := make(map[uint64]int)
+	var sameWeakChecksum map[int]int
 	var strongChecksums [][]byte
 
+	// lastBlocks maps a weak checksum shared by several blocks to the last of them, the end of its chain.
+	var lastBlocks map[uint64]int
+
+	weakChecksumSize := checksum.WeakChecksumSize()
+	weakChecksum := make([]byte, 8)
 	blockIndex := 0
+	offset := 12 + int64(len(key))
 	for {
-		var weakChecksum uint32
-		err := binary.Read(input, binary.BigEndian, &weakChecksum)
+		_, err := io.ReadFull(input, weakChecksum[8-weakChecksumSize:])
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, err
+			return nil, signatureError(err, offset, int64(blockIndex))
+		}
+		digest := binary.BigEndian.Uint64(weakChecksum)
+		if first, ok := weakChecksums[digest]; !ok {
+			weakChecksums[digest] = blockIndex
+		} else {
+			if sameWeakChecksum == nil {
+				sameWeakChecksum = make(map[int]int)
+				lastBlocks = make(map[uint64]int)
+			}
+			last, ok := lastBlocks[digest]
+			if !ok {
+				last = first
+			}
+			sameWeakChecksum[last] = blockIndex
+			lastBlocks[digest] = blockIndex
 		}
-		weakChecksums[weakChecksum] = blockIndex
-		blockIndex++
 
-		strongChecksum := make([]byte, strongChecksumSize)
+		strongChecksum := make([]byte, header.StrongChecksumSize)
 		if _, err = io.ReadFull(input, strongChecksum); err != nil {
-			return nil, err
+			return nil, signatureError(noEOF(err), offset, int64(blockIndex))
 		}
 		strongChecksums = append(strongChecksums, strongChecksum)
+
+		blockIndex++
+		offset += int64(weakChecksumSize) + int64(header.StrongChecksumSize)
 	}
 
 	return &Signature{
-		blockSize,
-		checksumType,
-		strongChecksumSize,
-		weakChecksums,
-		strongChecksums,
+		blockSize:          header.BlockSize,
+		checksumType:       header.ChecksumType,
+		strongChecksumSize: header.StrongChecksumSize,
+		key:                key,
+		weakChecksums:      weakChecksums,
+		sameWeakChecksum:   sameWeakChecksum,
+		strongChecksums:    strongChecksums,
 	}, nil
 }
+
+// findBlock returns the lowest index of the blocks whose checksums match data, or -1.
+// weakChecksum is the weak checksum of data computed by checksum.
+func (s *Signature) findBlock(checksum *Checksum, weakChecksum uint64, data []byte) (int, error) {
+	blockIndex, ok := s.weakChecksums[weakChecksum]
+	if !ok {
+		return -1, nil
+	}
+
+	strongChecksum, err := checksum.strongChecksum(data, s.strongChecksumSize)
+	if err != nil {
+		return -1, err
+	}
+
+	// The chain is in index order, the first match is the lowest one.
+	for ok {
+		if bytes.Equal(strongChecksum, s.strongChecksums[blockIndex]) {
+			return blockIndex, nil
+		}
+		blockIndex, ok = s.sameWeakChecksum[blockIndex]
+	}
+
+	return -1, nil
+}
+
+// writeWeakChecksum writes the size low bytes of weakChecksum in big-endian order.
+func writeWeakChecksum(out io.Writer, weakChecksum uint64, size uint32) error {
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, weakChecksum)
+	_, err := out.Write(buffer[8-size:])
+
+	return err
+}
@@ -3,10 +3,14 @@ package rdiff
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 
 	cryptoRand "crypto/rand"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
 )
 
 type TestSignatureData struct {
@@ -15,8 +19,9 @@ type TestSignatureData struct {
 
 	checksumType       ChecksumType
 	strongChecksumSize uint32
+	key                []byte
 
-	weakChecksums   []uint32
+	weakChecksums   []uint64
 	strongChecksums [][]byte
 }
 
@@ -24,7 +29,8 @@ func generateTestData() (data []TestSignatureData, err error) {
 	for _, blockSize := range BlockSizes {
 		for _, checksumType := range ChecksumTypes {
 			for _, strongChecksumSize := range StrongChecksumSizes {
-				checksum, err := NewChecksum(checksumType)
+				key := testKey(checksumType)
+				checksum, err := NewKeyedChecksum(checksumType, key)
 				if err != nil {
 					return nil, err
 				}
@@ -37,6 +43,7 @@ func generateTestData() (data []TestSignatureData, err error) {
 					blockSize:          blockSize,
 					checksumType:       checksumType,
 					strongChecksumSize: strongChecksumSize,
+					key:                key,
 				}
 
 				blockNumber := rand(1, 256)
@@ -52,7 +59,7 @@ func generateTestData() (data []TestSignatureData, err error) {
 					}
 					signatureData.fileContent = append(signatureData.fileContent, block...)
 
-					weakChecksum := checksum.CalculateWeakChecksum(block)
+					weakChecksum := checksum.CalculateWeakChecksum64(block)
 					signatureData.weakChecksums = append(signatureData.weakChecksums, weakChecksum)
 					strongChecksum, _ := checksum.CalculateStrongChecksum(block, signatureData.strongChecksumSize)
 					signatureData.strongChecksums = append(signatureData.strongChecksums, strongChecksum)
@@ -66,6 +73,11 @@ func generateTestData() (data []TestSignatureData, err error) {
 	return data, nil
 }
 
+func weakChecksumSize(checksumType ChecksumType) uint32 {
+	checksum, _ := NewKeyedChecksum(checksumType, testKey(checksumType))
+	return checksum.WeakChecksumSize()
+}
+
 func TestWriteSignature(t *testing.T) {
 	testData, err := generateTestData()
 	assert.Nil(t, err)
@@ -78,8 +90,9 @@ func TestWriteSignature(t *testing.T) {
 		assert.Nil(t, err)
 		err = binary.Write(expectedBuffer, binary.BigEndian, signatureData.strongChecksumSize)
 		assert.Nil(t, err)
+		expectedBuffer.Write(signatureData.key)
 		for i := 0; i < len(signatureData.weakChecksums); i++ {
-			err = binary.Write(expectedBuffer, binary.BigEndian, signatureData.weakChecksums[i])
+			err = writeWeakChecksum(expectedBuffer, signatureData.weakChecksums[i], weakChecksumSize(signatureData.checksumType))
 			assert.Nil(t, err)
 			n, err := expectedBuffer.Write(signatureData.strongChecksums[i])
 			assert.Nil(t, err)
@@ -89,7 +102,12 @@ func TestWriteSignature(t *testing.T) {
 		inputBuffer := bytes.NewReader(signatureData.fileContent)
 		outputBuffer := &bytes.Buffer{}
 		outputBuffer.Grow(expectedBuffer.Len())
-		err = WriteSignature(inputBuffer, outputBuffer, signatureData.checksumType, signatureData.blockSize, signatureData.strongChecksumSize)
+		if signatureData.key == nil {
+			err = WriteSignature(inputBuffer, outputBuffer, signatureData.checksumType, signatureData.blockSize, signatureData.strongChecksumSize)
+		} else {
+			// WriteSignature picks a random key
+			err = writeSignature(inputBuffer, outputBuffer, signatureData.checksumType, signatureData.blockSize, signatureData.strongChecksumSize, signatureData.key)
+		}
 		assert.Nil(t, err)
 
 		assert.Equal(t, expectedBuffer, outputBuffer)
@@ -112,12 +130,14 @@ func TestReadSignature(t *testing.T) {
 		expectedSignature.strongChecksumSize = signatureData.strongChecksumSize
 		err = binary.Write(inputBuffer, binary.BigEndian, signatureData.strongChecksumSize)
 		assert.Nil(t, err)
-		expectedSignature.weakChecksums = make(map[uint32]int)
+		expectedSignature.key = signatureData.key
+		inputBuffer.Write(signatureData.key)
+		expectedSignature.weakChecksums = make(map[uint64]int)
 		expectedSignature.strongChecksums = append(expectedSignature.strongChecksums, signatureData.strongChecksums...)
 		for i := 0; i < len(signatureData.weakChecksums); i++ {
 			weakChecksum := signatureData.weakChecksums[i]
 			expectedSignature.weakChecksums[weakChecksum] = i
-			err = binary.Write(inputBuffer, binary.BigEndian, weakChecksum)
+			err = writeWeakChecksum(inputBuffer, weakChecksum, weakChecksumSize(signatureData.checksumType))
 			assert.Nil(t, err)
 			n, err := inputBuffer.Write(signatureData.strongChecksums[i])
 			assert.Nil(t, err)
@@ -130,3 +150,74 @@ func TestReadSignature(t *testing.T) {
 		assert.Equal(t, expectedSignature, actualSignature)
 	}
 }
+
+func TestWriteSignature_Keyed(t *testing.T) {
+	for _, checksumType := range []ChecksumType{Rollsum_Blake2b_Keyed, Rabinkarp_Blake2b_Keyed} {
+		// Generate file
+		_, blockSize, _, originalFile, err := generateFile(10, 100)
+		assert.Nil(t, err)
+		newFile := append([]byte{1, 2, 3}, originalFile...)
+
+		_, err = NewChecksum(checksumType)
+		assert.True(t, errors.Is(err, ErrInvalidChecksumKey))
+
+		// Every signature gets its own key
+		first := &bytes.Buffer{}
+		assert.Nil(t, WriteSignature(bytes.NewReader(originalFile), first, checksumType, uint32(blockSize), 32))
+		second := &bytes.Buffer{}
+		assert.Nil(t, WriteSignature(bytes.NewReader(originalFile), second, checksumType, uint32(blockSize), 32))
+		assert.NotEqual(t, first.Bytes()[12:12+Blake2bKeySize], second.Bytes()[12:12+Blake2bKeySize])
+
+		signature, err := ReadSignature(bytes.NewReader(first.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, first.Bytes()[12:12+Blake2bKeySize], signature.key)
+
+		h, err := blake2b.New256(signature.key)
+		assert.Nil(t, err)
+		h.Write(originalFile[:blockSize])
+		assert.Equal(t, h.Sum(nil), signature.strongChecksums[0])
+
+		// The delta is computed with the key of the signature
+		delta := &bytes.Buffer{}
+		assert.Nil(t, WriteDelta(signature, bytes.NewReader(newFile), delta, uint32(blockSize*2)))
+		actualNewFile := &bytes.Buffer{}
+		assert.Nil(t, Patch(bytes.NewReader(originalFile), actualNewFile, delta))
+		assert.Equal(t, newFile, actualNewFile.Bytes())
+
+		stats, err := EstimateDelta(signature, bytes.NewReader(newFile), uint32(blockSize*2))
+		assert.Nil(t, err)
+		assert.Positive(t, stats.CopyCommands)
+
+		signature.key[0]++
+		stats, err = EstimateDelta(signature, bytes.NewReader(newFile), uint32(blockSize*2))
+		assert.Nil(t, err)
+		assert.Zero(t, stats.CopyCommands)
+
+		// Truncated key
+		_, err = ReadSignature(bytes.NewReader(first.Bytes()[:20]))
+		var signatureError *SignatureError
+		assert.True(t, errors.As(err, &signatureError))
+		assert.Equal(t, int64(-1), signatureError.Block)
+		assert.True(t, errors.Is(err, ErrTruncatedSignature))
+	}
+}
+
+func BenchmarkWriteSignature(b *testing.B) {
+	const blockSize = 2048
+
+	originalFile, err := generateBytes(8 << 20)
+	assert.Nil(b, err)
+
+	for _, checksumType := range ChecksumTypes {
+		b.Run(fmt.Sprintf("%#x", checksumType), func(b *testing.B) {
+			b.SetBytes(int64(len(originalFile)))
+			b.ReportAllocs()
+
+			for n := 0; n < b.N; n++ {
+				if err = WriteSignature(bytes.NewReader(originalFile), io.Discard, checksumType, blockSize, 16); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
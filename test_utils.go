@@ -9,7 +9,7 @@ import (
 
 var (
 	BlockSizes          = []uint32{100, 200, 500}
-	ChecksumTypes       = []ChecksumType{Rollsum_Md4, Rollsum_Blake2b, Rabinkarp_Md4, Rabinkarp_Blake2b}
+	ChecksumTypes       = []ChecksumType{Rollsum_Md4, Rollsum_Blake2b, Rabinkarp_Md4, Rabinkarp_Blake2b, Rollsum_Sha256, Rabinkarp_Sha256, Rollsum_Blake2b_Keyed, Rabinkarp_Blake2b_Keyed, Rabinkarp64_Blake2b, Buzhash_Md4, Buzhash_Blake2b}
 	StrongChecksumSizes = []uint32{8, 16, 32}
 )
 
@@ -37,6 +37,17 @@ func rand64(begin, end int) uint64 {
 	return uint64(mathRand.Intn(end-begin) + begin)
 }
 
+// testKey returns a random key for keyed checksum types and nil for the others.
+func testKey(checksumType ChecksumType) []byte {
+	algorithm, err := LookupChecksumAlgorithm(checksumType)
+	if err != nil || algorithm.KeySize == 0 {
+		return nil
+	}
+
+	key, _ := generateBytes(uint64(algorithm.KeySize))
+	return key
+}
+
 func generateBytes(size uint64) (block []byte, err error) {
 	block = make([]byte, size)
 	_, err = cryptoRand.Read(block)
@@ -0,0 +1,90 @@
+#!/bin/sh
+# Generates the librsync corpus TestLibrsyncCorpus checks, with rdiff 2.3 or later on the PATH:
+#
+#	cd testdata/librsync && ./generate.sh
+#
+# and is checked with go test -tags interop -run TestLibrsyncCorpus.
+#
+# Every case directory holds the basis and new files, basis.sig made by rdiff signature and new.delta made by
+# rdiff delta. librsync only makes the Rollsum_Md4, Rollsum_Blake2b, Rabinkarp_Md4 and Rabinkarp_Blake2b
+# checksum types, the other checksum types of this package have no librsync counterpart.
+set -eu
+
+cd "$(dirname "$0")"
+
+block_size=1024
+
+# random size writes size random bytes to stdout.
+random() {
+	head -c "$1" /dev/urandom
+}
+
+# scenario name makes the basis and new files of a scenario in a temporary directory.
+scenario() {
+	dir="$tmp/$1"
+	mkdir -p "$dir"
+	case "$1" in
+	empty)
+		: >"$dir/basis"
+		random 3000 >"$dir/new"
+		;;
+	nochange)
+		random 100000 >"$dir/basis"
+		cp "$dir/basis" "$dir/new"
+		;;
+	prepend)
+		random 100000 >"$dir/basis"
+		{ random 777; cat "$dir/basis"; } >"$dir/new"
+		;;
+	append)
+		random 100000 >"$dir/basis"
+		{ cat "$dir/basis"; random 777; } >"$dir/new"
+		;;
+	insert)
+		random 100000 >"$dir/basis"
+		{ head -c 40960 "$dir/basis"; random 333; tail -c +40961 "$dir/basis"; } >"$dir/new"
+		;;
+	modify)
+		random 100000 >"$dir/basis"
+		{ head -c 20480 "$dir/basis"; random $block_size; tail -c +$((20481 + block_size)) "$dir/basis"; } >"$dir/new"
+		;;
+	remove)
+		random 100000 >"$dir/basis"
+		{ head -c 20480 "$dir/basis"; tail -c +$((20481 + block_size)) "$dir/basis"; } >"$dir/new"
+		;;
+	tail)
+		random 100000 >"$dir/basis"
+		{ random 5000; tail -c 1000 "$dir/basis"; } >"$dir/new"
+		;;
+	duplicate)
+		random $block_size >"$dir/block"
+		cat "$dir/block" "$dir/block" "$dir/block" >"$dir/basis"
+		{ random 100; cat "$dir/block"; } >"$dir/new"
+		rm "$dir/block"
+		;;
+	literal)
+		random 100000 >"$dir/basis"
+		random 100000 >"$dir/new"
+		;;
+	esac
+}
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+for name in empty nochange prepend append insert modify remove tail duplicate literal; do
+	scenario "$name"
+done
+
+for hash in md4 blake2; do
+	for rollsum in rollsum rabinkarp; do
+		for name in empty nochange prepend append insert modify remove tail duplicate literal; do
+			dir="$rollsum-$hash-$name"
+			rm -rf "$dir"
+			mkdir "$dir"
+			cp "$tmp/$name/basis" "$tmp/$name/new" "$dir"
+			rdiff -b $block_size -S 8 -H $hash -R $rollsum signature "$dir/basis" "$dir/basis.sig"
+			rdiff delta "$dir/basis.sig" "$dir/new" "$dir/new.delta"
+		done
+	done
+done
@@ -0,0 +1,71 @@
+#!/bin/sh
+# Generates the VCDIFF corpus TestVCDIFFCorpus checks, with xdelta3 and the vcdiff tool of open-vcdiff on the PATH:
+#
+#	cd testdata/vcdiff && ./generate.sh
+#
+# and is checked with go test -tags interop -run TestVCDIFFCorpus.
+#
+# Every case directory holds the source and target files and target.vcdiff, the delta the tool made from them.
+# xdelta3 runs without secondary compression and with 16 KiB windows, so that its deltas have several windows.
+# open-vcdiff runs without its interleaved format and checksums, which change the VCDIFF header version.
+set -eu
+
+cd "$(dirname "$0")"
+
+# random size writes size random bytes to stdout.
+random() {
+	head -c "$1" /dev/urandom
+}
+
+# scenario name makes the source and target files of a scenario in a temporary directory.
+scenario() {
+	dir="$tmp/$1"
+	mkdir -p "$dir"
+	case "$1" in
+	empty)
+		: >"$dir/source"
+		random 3000 >"$dir/target"
+		;;
+	nochange)
+		random 100000 >"$dir/source"
+		cp "$dir/source" "$dir/target"
+		;;
+	insert)
+		random 100000 >"$dir/source"
+		{ head -c 40960 "$dir/source"; random 333; tail -c +40961 "$dir/source"; } >"$dir/target"
+		;;
+	modify)
+		random 100000 >"$dir/source"
+		{ head -c 20480 "$dir/source"; random 1000; tail -c +21481 "$dir/source"; } >"$dir/target"
+		;;
+	repeat)
+		random 1000 >"$dir/source"
+		{ cat "$dir/source" "$dir/source" "$dir/source"; head -c 10000 /dev/zero; } >"$dir/target"
+		;;
+	esac
+}
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+scenarios="empty nochange insert modify repeat"
+for name in $scenarios; do
+	scenario "$name"
+done
+
+for tool in xdelta3 open-vcdiff; do
+	for name in $scenarios; do
+		dir="$tool-$name"
+		rm -rf "$dir"
+		mkdir "$dir"
+		cp "$tmp/$name/source" "$tmp/$name/target" "$dir"
+		case "$tool" in
+		xdelta3)
+			xdelta3 -e -f -S none -W 16384 -s "$dir/source" "$dir/target" "$dir/target.vcdiff"
+			;;
+		open-vcdiff)
+			vcdiff encode -dictionary "$dir/source" -target "$dir/target" -delta "$dir/target.vcdiff"
+			;;
+		esac
+	done
+done
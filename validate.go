@@ -0,0 +1,50 @@
+package rdiff
+
+import (
+	"io"
+)
+
+// ValidateDeltaSignature checks the structure of delta and that every copy command stays within the blocks of
+// the original file described by signature. The check is approximate: the signature does not record the size of
+// the short last block, so every block is taken as blockSize long and a copy running up to blockSize-1 bytes past
+// the end of the original file is accepted. ValidateDelta with the size of the original file checks copies exactly.
+func ValidateDeltaSignature(delta io.Reader, signature *Signature) error {
+	return ValidateDelta(delta, int64(len(signature.strongChecksums))*int64(signature.blockSize))
+}
+
+// ValidateDelta reads the whole delta and checks the magic number, that all command codes are known, that the
+// delta has exactly one end command with no data after it and, unless originalSize is negative, that every copy
+// command stays within an original file of originalSize bytes. The first problem is returned as a *DeltaError.
+func ValidateDelta(delta io.Reader, originalSize int64) error {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		switch command.Type {
+		case End:
+			n, err := io.ReadFull(reader.in, make([]byte, 1))
+			if n > 0 {
+				return &DeltaError{Offset: reader.in.count - 1, Command: reader.Index() + 1, Err: ErrTrailingData}
+			}
+			if err != io.EOF {
+				return &DeltaError{Offset: reader.in.count, Command: reader.Index() + 1, Err: err}
+			}
+			return nil
+		case Literal:
+			if _, err := io.Copy(io.Discard, command.Data); err != nil {
+				return deltaError(err, reader.Offset(), reader.Index())
+			}
+		case Copy:
+			if originalSize >= 0 && command.Position+command.Length > uint64(originalSize) {
+				return &DeltaError{Offset: reader.Offset(), Command: reader.Index(), Err: ErrCopyOutOfRange}
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package rdiff
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDelta(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(2, 100)
+		assert.Nil(t, err)
+
+		// Remove one of the blocks
+		blockIndex := rand64(0, int(blockNumber)-2)
+		newFile := make([]byte, 0)
+		newFile = append(newFile, originalFile[:blockIndex*blockSize]...)
+		newFile = append(newFile, originalFile[(blockIndex+1)*blockSize:]...)
+
+		// Calculate signature and delta
+		signatureBuffer := &bytes.Buffer{}
+		err = WriteSignature(bytes.NewReader(originalFile), signatureBuffer, checksumType, uint32(blockSize), 8)
+		assert.Nil(t, err)
+		signature, err := ReadSignature(signatureBuffer)
+		assert.Nil(t, err)
+		delta := &bytes.Buffer{}
+		err = WriteDelta(signature, bytes.NewReader(newFile), delta, uint32(blockSize*2))
+		assert.Nil(t, err)
+
+		assert.Nil(t, ValidateDelta(bytes.NewReader(delta.Bytes()), int64(len(originalFile))))
+		assert.Nil(t, ValidateDelta(bytes.NewReader(delta.Bytes()), -1))
+		assert.Nil(t, ValidateDeltaSignature(bytes.NewReader(delta.Bytes()), signature))
+	}
+}
+
+func TestValidateDelta_InvalidDelta(t *testing.T) {
+	testCases := []struct {
+		delta   []byte
+		offset  int64
+		command int64
+		err     error
+	}{
+		// Trailing data
+		{[]byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, 0, 0}, 7, 2, ErrTrailingData},
+		// Copy beyond the end of the original file
+		{[]byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, MinCopyCommand, 90, 20, 0}, 6, 1, ErrCopyOutOfRange},
+		// Missing end command
+		{[]byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, MinCopyCommand, 0, 20}, 9, 2, ErrTruncatedDelta},
+		// Truncated literal
+		{[]byte{0x72, 0x73, 0x02, 0x36, MinCopyCommand, 0, 20, 3, 0xaa}, 7, 1, ErrTruncatedDelta},
+		// Truncated parameter
+		{[]byte{0x72, 0x73, 0x02, 0x36, MinCopyCommand + 5, 0, 20}, 4, 0, ErrTruncatedDelta},
+	}
+
+	for _, testCase := range testCases {
+		err := ValidateDelta(bytes.NewReader(testCase.delta), 100)
+		var deltaError *DeltaError
+		assert.True(t, errors.As(err, &deltaError))
+		assert.Equal(t, testCase.offset, deltaError.Offset)
+		assert.Equal(t, testCase.command, deltaError.Command)
+		assert.True(t, errors.Is(err, testCase.err))
+	}
+
+	// Unknown command code
+	err := ValidateDelta(bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 2, 0xaa, 0xbb, MinReservedCommand, 0}), 100)
+	var deltaError *DeltaError
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(7), deltaError.Offset)
+	assert.Equal(t, int64(1), deltaError.Command)
+
+	// Invalid magic number
+	err = ValidateDelta(bytes.NewReader([]byte{0x72, 0x73, 0x01, 0x36, 0}), 100)
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(-1), deltaError.Command)
+}
+
+func TestValidateDelta_ReadErrorAfterEnd(t *testing.T) {
+	readErr := errors.New("read failed")
+	delta := io.MultiReader(bytes.NewReader([]byte{0x72, 0x73, 0x02, 0x36, 1, 0xaa, 0}), iotest.ErrReader(readErr))
+
+	err := ValidateDelta(delta, 100)
+	var deltaError *DeltaError
+	assert.True(t, errors.As(err, &deltaError))
+	assert.Equal(t, int64(7), deltaError.Offset)
+	assert.Equal(t, int64(2), deltaError.Command)
+	assert.True(t, errors.Is(err, readErr))
+}
+
+func TestValidateDeltaSignature_LastBlock(t *testing.T) {
+	// The signature does not record the size of the last block, copies are checked against whole blocks
+	originalFile, err := generateBytes(150)
+	assert.Nil(t, err)
+	signature := readTestSignature(t, originalFile, Rabinkarp_Blake2b, 100)
+
+	for _, testCase := range []struct {
+		length uint64
+		err    error
+	}{{150, nil}, {200, nil}, {201, ErrCopyOutOfRange}} {
+		delta := &bytes.Buffer{}
+		writer, err := NewDeltaWriter(delta)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteCopy(0, testCase.length))
+		assert.Nil(t, writer.Close())
+
+		err = ValidateDeltaSignature(delta, signature)
+		assert.True(t, errors.Is(err, testCase.err), testCase.length)
+	}
+}
@@ -0,0 +1,817 @@
+package rdiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"math"
+)
+
+// VCDIFF (RFC 3284) header and window indicator bits. vcdiffWindowAdler32 is the Adler-32 checksum of the target
+// window that xdelta3 and open-vcdiff add to the format.
+const (
+	vcdiffHeaderDecompress = 0x01
+	vcdiffHeaderCodeTable  = 0x02
+	vcdiffHeaderAppHeader  = 0x04
+
+	vcdiffWindowSource  = 0x01
+	vcdiffWindowTarget  = 0x02
+	vcdiffWindowAdler32 = 0x04
+
+	// vcdiffMaxWindowSize is the max size of the target windows WriteVCDIFF writes, well below the limits of the
+	// xdelta3 and open-vcdiff decoders.
+	vcdiffMaxWindowSize = 1 << 22
+
+	// vcdiffMaxTargetWindowSize is the max size of the target windows PatchVCDIFF decodes. Windows copying from
+	// their own target window are held in memory while they are decoded.
+	vcdiffMaxTargetWindowSize = 1 << 26
+
+	// vcdiffRunChunkSize is the size of the writes of RUN instructions.
+	vcdiffRunChunkSize = 32 << 10
+
+	// vcdiffMinRun is the min number of repeated literal bytes WriteVCDIFF writes as a RUN instruction.
+	vcdiffMinRun = 8
+
+	// vcdiffNearSize and vcdiffSameSize are the sizes of the address caches of the default code table.
+	vcdiffNearSize = 4
+	vcdiffSameSize = 3
+)
+
+// VCDIFF instruction types.
+const (
+	vcdiffNoop byte = iota
+	vcdiffAdd
+	vcdiffRun
+	vcdiffCopy
+)
+
+// Codes of the default code table for the instructions WriteVCDIFF writes, all with their size in the instructions
+// section and COPY with its address in VCD_SELF mode.
+const (
+	vcdiffRunCode  byte = 0
+	vcdiffAddCode  byte = 1
+	vcdiffCopyCode byte = 19
+)
+
+var vcdiffMagic = []byte{0xd6, 0xc3, 0xc4, 0x00}
+
+type vcdiffInstruction struct {
+	inst byte
+	size byte
+	mode byte
+}
+
+// vcdiffCodeTable is the default code table of RFC 3284 section 5.6.
+var vcdiffCodeTable = newVCDIFFCodeTable()
+
+func newVCDIFFCodeTable() *[256][2]vcdiffInstruction {
+	table := &[256][2]vcdiffInstruction{}
+	table[0][0] = vcdiffInstruction{inst: vcdiffRun}
+
+	code := 1
+	for size := 0; size <= 17; size++ {
+		table[code][0] = vcdiffInstruction{inst: vcdiffAdd, size: byte(size)}
+		code++
+	}
+
+	for mode := 0; mode < 2+vcdiffNearSize+vcdiffSameSize; mode++ {
+		table[code][0] = vcdiffInstruction{inst: vcdiffCopy, mode: byte(mode)}
+		code++
+		for size := 4; size <= 18; size++ {
+			table[code][0] = vcdiffInstruction{inst: vcdiffCopy, size: byte(size), mode: byte(mode)}
+			code++
+		}
+	}
+
+	for mode := 0; mode < 2+vcdiffNearSize; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			for copySize := 4; copySize <= 6; copySize++ {
+				table[code][0] = vcdiffInstruction{inst: vcdiffAdd, size: byte(addSize)}
+				table[code][1] = vcdiffInstruction{inst: vcdiffCopy, size: byte(copySize), mode: byte(mode)}
+				code++
+			}
+		}
+	}
+
+	for mode := 2 + vcdiffNearSize; mode < 2+vcdiffNearSize+vcdiffSameSize; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			table[code][0] = vcdiffInstruction{inst: vcdiffAdd, size: byte(addSize)}
+			table[code][1] = vcdiffInstruction{inst: vcdiffCopy, size: 4, mode: byte(mode)}
+			code++
+		}
+	}
+
+	for mode := 0; mode < 2+vcdiffNearSize+vcdiffSameSize; mode++ {
+		table[code][0] = vcdiffInstruction{inst: vcdiffCopy, size: 4, mode: byte(mode)}
+		table[code][1] = vcdiffInstruction{inst: vcdiffAdd, size: 1}
+		code++
+	}
+
+	return table
+}
+
+// writeVarint appends value to out as a VCDIFF integer, base 128 with the most significant digit first.
+func writeVarint(out *bytes.Buffer, value uint64) {
+	var buffer [10]byte
+	i := len(buffer) - 1
+	buffer[i] = byte(value & 0x7f)
+	for value >>= 7; value > 0; value >>= 7 {
+		i--
+		buffer[i] = byte(value&0x7f) | 0x80
+	}
+
+	out.Write(buffer[i:])
+}
+
+// varintLength returns the number of bytes writeVarint writes for value.
+func varintLength(value uint64) uint64 {
+	length := uint64(1)
+	for value >>= 7; value > 0; value >>= 7 {
+		length++
+	}
+
+	return length
+}
+
+func readVarint(in io.ByteReader) (uint64, error) {
+	var value uint64
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+
+		if value > math.MaxUint64>>7 {
+			return 0, fmt.Errorf("%w: integer overflows 64 bits", ErrInvalidVCDIFF)
+		}
+		value = value<<7 | uint64(b&0x7f)
+
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+}
+
+// vcdiffAddressCache is the near and same address cache of RFC 3284 section 5.1, reset at every window.
+type vcdiffAddressCache struct {
+	near     [vcdiffNearSize]uint64
+	nextSlot int
+	same     [vcdiffSameSize * 256]uint64
+}
+
+// decode reads the address of a COPY instruction in mode from addresses. here is the current position in the
+// address space of the window, the source segment followed by the target window.
+func (c *vcdiffAddressCache) decode(addresses *bytes.Reader, here uint64, mode byte) (uint64, error) {
+	var address uint64
+	if mode < 2+vcdiffNearSize {
+		offset, err := readVarint(addresses)
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case mode == 0:
+			address = offset
+		case mode == 1:
+			if offset > here {
+				return 0, fmt.Errorf("%w: copy offset %d before the start of the window", ErrInvalidVCDIFF, offset)
+			}
+			address = here - offset
+		default:
+			address = c.near[mode-2] + offset
+		}
+	} else {
+		b, err := addresses.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		address = c.same[int(mode-2-vcdiffNearSize)*256+int(b)]
+	}
+
+	if address >= here {
+		return 0, fmt.Errorf("%w: copy address %d not before current position %d", ErrInvalidVCDIFF, address, here)
+	}
+
+	c.near[c.nextSlot] = address
+	c.nextSlot = (c.nextSlot + 1) % vcdiffNearSize
+	c.same[address%(vcdiffSameSize*256)] = address
+
+	return address, nil
+}
+
+type vcdiffPendingInstruction struct {
+	inst     byte
+	size     uint64
+	position uint64
+}
+
+// vcdiffEncoder collects the instructions of a target window and writes the window once it is full.
+type vcdiffEncoder struct {
+	out io.Writer
+
+	instructions []vcdiffPendingInstruction
+	data         []byte
+	targetSize   uint64
+
+	// sourceBegin and sourceEnd are the original file range of the COPY instructions of the window.
+	sourceBegin uint64
+	sourceEnd   uint64
+
+	// literalData, instSection, addrSection and encoding are reused for every window.
+	literalData []byte
+	instSection bytes.Buffer
+	addrSection bytes.Buffer
+	encoding    bytes.Buffer
+}
+
+func (e *vcdiffEncoder) literal(data io.Reader, length uint64) error {
+	for length > 0 {
+		chunkSize := vcdiffMaxWindowSize - e.targetSize
+		if chunkSize > length {
+			chunkSize = length
+		}
+
+		if uint64(cap(e.literalData)) < chunkSize {
+			e.literalData = make([]byte, chunkSize)
+		}
+		chunk := e.literalData[:chunkSize]
+		if _, err := io.ReadFull(data, chunk); err != nil {
+			return noEOF(err)
+		}
+		length -= chunkSize
+
+		// Runs of repeated bytes become RUN instructions, everything else ADD instructions.
+		for i := 0; i < len(chunk); {
+			j := i + 1
+			for j < len(chunk) && chunk[j] == chunk[i] {
+				j++
+			}
+
+			if j-i >= vcdiffMinRun {
+				e.instructions = append(e.instructions, vcdiffPendingInstruction{inst: vcdiffRun, size: uint64(j - i)})
+				e.data = append(e.data, chunk[i])
+			} else if last := len(e.instructions) - 1; last >= 0 && e.instructions[last].inst == vcdiffAdd {
+				e.instructions[last].size += uint64(j - i)
+				e.data = append(e.data, chunk[i:j]...)
+			} else {
+				e.instructions = append(e.instructions, vcdiffPendingInstruction{inst: vcdiffAdd, size: uint64(j - i)})
+				e.data = append(e.data, chunk[i:j]...)
+			}
+			i = j
+		}
+
+		if err := e.addTargetSize(chunkSize); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *vcdiffEncoder) copy(position, length uint64) error {
+	for length > 0 {
+		chunkSize := vcdiffMaxWindowSize - e.targetSize
+		if chunkSize > length {
+			chunkSize = length
+		}
+
+		if e.sourceEnd == 0 || position < e.sourceBegin {
+			e.sourceBegin = position
+		}
+		if position+chunkSize > e.sourceEnd {
+			e.sourceEnd = position + chunkSize
+		}
+		e.instructions = append(e.instructions, vcdiffPendingInstruction{inst: vcdiffCopy, size: chunkSize, position: position})
+
+		position += chunkSize
+		length -= chunkSize
+
+		if err := e.addTargetSize(chunkSize); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addTargetSize accounts for size bytes added to the target window and writes the window once it is full.
+func (e *vcdiffEncoder) addTargetSize(size uint64) error {
+	e.targetSize += size
+	if e.targetSize < vcdiffMaxWindowSize {
+		return nil
+	}
+
+	return e.flush()
+}
+
+// flush writes the collected instructions as a target window. The window has a source segment when it copies from
+// the original file, COPY addresses are then relative to the start of the segment.
+func (e *vcdiffEncoder) flush() error {
+	if e.targetSize == 0 {
+		return nil
+	}
+
+	e.instSection.Reset()
+	e.addrSection.Reset()
+	for _, instruction := range e.instructions {
+		switch instruction.inst {
+		case vcdiffRun:
+			e.instSection.WriteByte(vcdiffRunCode)
+		case vcdiffAdd:
+			e.instSection.WriteByte(vcdiffAddCode)
+		case vcdiffCopy:
+			e.instSection.WriteByte(vcdiffCopyCode)
+			writeVarint(&e.addrSection, instruction.position-e.sourceBegin)
+		}
+		writeVarint(&e.instSection, instruction.size)
+	}
+
+	encoding := &e.encoding
+	encoding.Reset()
+	writeVarint(encoding, e.targetSize)
+	encoding.WriteByte(0)
+	writeVarint(encoding, uint64(len(e.data)))
+	writeVarint(encoding, uint64(e.instSection.Len()))
+	writeVarint(encoding, uint64(e.addrSection.Len()))
+	encodingLength := uint64(encoding.Len() + len(e.data) + e.instSection.Len() + e.addrSection.Len())
+
+	window := &bytes.Buffer{}
+	if e.sourceEnd > 0 {
+		window.WriteByte(vcdiffWindowSource)
+		writeVarint(window, e.sourceEnd-e.sourceBegin)
+		writeVarint(window, e.sourceBegin)
+	} else {
+		window.WriteByte(0)
+	}
+	writeVarint(window, encodingLength)
+
+	for _, section := range [][]byte{window.Bytes(), encoding.Bytes(), e.data, e.instSection.Bytes(), e.addrSection.Bytes()} {
+		if _, err := e.out.Write(section); err != nil {
+			return err
+		}
+	}
+
+	e.instructions = e.instructions[:0]
+	e.data = e.data[:0]
+	e.targetSize = 0
+	e.sourceBegin, e.sourceEnd = 0, 0
+
+	return nil
+}
+
+// WriteVCDIFF converts delta into a VCDIFF (RFC 3284) delta written to out. Literal commands become ADD and RUN
+// instructions and copy commands become COPY instructions from a source segment of the original file. The target
+// windows are at most 4 MiB, without checksum since the original file is not read.
+func WriteVCDIFF(delta io.Reader, out io.Writer) error {
+	reader, err := NewDeltaReader(delta)
+	if err != nil {
+		return err
+	}
+
+	// The header indicator is 0, WriteVCDIFF uses the default code table without secondary compression.
+	if _, err = out.Write(append(append([]byte{}, vcdiffMagic...), 0)); err != nil {
+		return err
+	}
+
+	encoder := &vcdiffEncoder{out: out}
+	for {
+		command, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		switch command.Type {
+		case End:
+			return encoder.flush()
+		case Literal:
+			err = encoder.literal(command.Data, command.Length)
+		case Copy:
+			err = encoder.copy(command.Position, command.Length)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// PatchVCDIFF applies the VCDIFF (RFC 3284) delta to originalFile like Patch, as made by WriteVCDIFF, xdelta3 or
+// open-vcdiff with the default code table. Secondary compression, application-defined code tables and windows
+// copying from the target file are reported as ErrUnsupportedVCDIFF.
+func PatchVCDIFF(originalFile io.ReadSeeker, newFile io.Writer, delta io.Reader) error {
+	return PatchVCDIFFWithOptions(originalFile, newFile, delta, nil)
+}
+
+// PatchVCDIFFWithOptions applies delta like PatchVCDIFF with the limits of options, which apply to VCDIFF
+// instructions like to delta commands in PatchWithOptions: a window is rejected with a *LimitError before any of it
+// is written. The other options are not used. Options may be nil.
+//
+// The instructions of a window are checked before it is decoded, and target windows larger than 64 MiB are
+// reported as ErrUnsupportedVCDIFF. The target window is then written as it is decoded, except for windows that
+// copy from their own target window, which are decoded in memory first. The Adler-32 checksum of a window is checked
+// once the window is decoded, so a window failing with ErrVCDIFFChecksum may already be written.
+func PatchVCDIFFWithOptions(originalFile io.ReadSeeker, newFile io.Writer, delta io.Reader, options *PatchOptions) error {
+	if options == nil {
+		options = &PatchOptions{}
+	}
+
+	in := bufio.NewReader(delta)
+	if err := readVCDIFFHeader(in); err != nil {
+		return vcdiffError(err)
+	}
+
+	decoder := &vcdiffDecoder{originalFile: originalFile, options: options}
+	for window := 0; ; window++ {
+		if _, err := in.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := decoder.decodeWindow(in, newFile); err != nil {
+			return fmt.Errorf("vcdiff window %d: %w", window, vcdiffError(err))
+		}
+	}
+}
+
+// vcdiffError turns a premature end of a VCDIFF delta into ErrTruncatedDelta.
+func vcdiffError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncatedDelta
+	}
+
+	return err
+}
+
+func readVCDIFFHeader(in *bufio.Reader) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header[:4], vcdiffMagic) {
+		return fmt.Errorf("%w %#x, expected %#x", ErrBadMagic, header[:4], vcdiffMagic)
+	}
+
+	indicator := header[4]
+	if indicator&vcdiffHeaderDecompress != 0 {
+		return fmt.Errorf("%w: secondary compression", ErrUnsupportedVCDIFF)
+	}
+	if indicator&vcdiffHeaderCodeTable != 0 {
+		return fmt.Errorf("%w: application-defined code table", ErrUnsupportedVCDIFF)
+	}
+	if indicator&^(vcdiffHeaderDecompress|vcdiffHeaderCodeTable|vcdiffHeaderAppHeader) != 0 {
+		return fmt.Errorf("%w: header indicator %#x", ErrInvalidVCDIFF, indicator)
+	}
+
+	if indicator&vcdiffHeaderAppHeader != 0 {
+		length, err := readVarint(in)
+		if err != nil {
+			return err
+		}
+		if length > math.MaxInt64 {
+			return ErrParameterOverflow
+		}
+		if _, err = io.CopyN(io.Discard, in, int64(length)); err != nil {
+			return noEOF(err)
+		}
+	}
+
+	return nil
+}
+
+// byteCounter counts the bytes read from in.
+type byteCounter struct {
+	in    io.ByteReader
+	count uint64
+}
+
+func (c *byteCounter) ReadByte() (byte, error) {
+	b, err := c.in.ReadByte()
+	if err == nil {
+		c.count++
+	}
+
+	return b, err
+}
+
+// vcdiffMaxEncodingLength returns the max length of the delta encoding of a target window of targetSize bytes with
+// a source segment of sourceSize bytes. The longest encoding has an instruction for every target byte, with a code,
+// an explicit size, an address and a data byte, after the target window size, the delta indicator, the three section
+// lengths and the checksum. Only instructions of size 0 could make a longer encoding.
+func vcdiffMaxEncodingLength(targetSize, sourceSize uint64) uint64 {
+	header := varintLength(targetSize) + 1 + 3*varintLength(math.MaxUint64) + 4
+	instruction := 1 + varintLength(targetSize) + varintLength(sourceSize+targetSize) + 1
+
+	return header + targetSize*instruction
+}
+
+// vcdiffOperation is a decoded instruction of a target window. offset is the position of ADD and RUN data in the
+// data section and the address of COPY in the window address space.
+type vcdiffOperation struct {
+	inst   byte
+	size   uint64
+	offset uint64
+}
+
+type vcdiffDecoder struct {
+	originalFile io.ReadSeeker
+	options      *PatchOptions
+
+	// outputSize is the size of the target windows written so far.
+	outputSize uint64
+
+	// encoding, operations, target and run are reused for every window.
+	encoding   bytes.Buffer
+	operations []vcdiffOperation
+	target     bytes.Buffer
+	run        []byte
+	cache      vcdiffAddressCache
+}
+
+// decodeWindow decodes the next window of in and writes its target window to newFile.
+func (d *vcdiffDecoder) decodeWindow(in *bufio.Reader, newFile io.Writer) error {
+	indicator, err := in.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if indicator&vcdiffWindowTarget != 0 {
+		return fmt.Errorf("%w: source segment from the target file", ErrUnsupportedVCDIFF)
+	}
+	if indicator&^(vcdiffWindowSource|vcdiffWindowTarget|vcdiffWindowAdler32) != 0 {
+		return fmt.Errorf("%w: window indicator %#x", ErrInvalidVCDIFF, indicator)
+	}
+
+	var sourceSize, sourcePosition uint64
+	if indicator&vcdiffWindowSource != 0 {
+		if sourceSize, err = readVarint(in); err != nil {
+			return err
+		}
+		if sourcePosition, err = readVarint(in); err != nil {
+			return err
+		}
+		if sourceSize > math.MaxInt64 || sourcePosition > math.MaxInt64-sourceSize {
+			return ErrParameterOverflow
+		}
+	}
+
+	encodingLength, err := readVarint(in)
+	if err != nil {
+		return err
+	}
+	if encodingLength > math.MaxInt64 {
+		return ErrParameterOverflow
+	}
+
+	// The target window size leads the delta encoding and bounds the rest of it, both are checked before the delta
+	// encoding is read into memory.
+	counter := &byteCounter{in: in}
+	targetSize, err := readVarint(counter)
+	if err != nil {
+		return err
+	}
+	if targetSize > vcdiffMaxTargetWindowSize {
+		return fmt.Errorf("%w: target window of %d bytes exceeds %d bytes", ErrUnsupportedVCDIFF, targetSize, vcdiffMaxTargetWindowSize)
+	}
+	if limit := d.options.MaxOutputSize; limit > 0 && d.outputSize+targetSize > uint64(limit) {
+		return &LimitError{Limit: "MaxOutputSize", Value: d.outputSize + targetSize, Max: limit}
+	}
+	if encodingLength < counter.count {
+		return fmt.Errorf("%w: delta encoding of %d bytes ends within the target window size", ErrInvalidVCDIFF, encodingLength)
+	}
+	if maxLength := vcdiffMaxEncodingLength(targetSize, sourceSize); encodingLength > maxLength {
+		return fmt.Errorf("%w: delta encoding of %d bytes exceeds %d bytes for a target window of %d bytes", ErrUnsupportedVCDIFF, encodingLength, maxLength, targetSize)
+	}
+
+	// The delta encoding is read as it arrives so that a truncated delta does not allocate it upfront.
+	d.encoding.Reset()
+	if _, err = io.CopyN(&d.encoding, in, int64(encodingLength-counter.count)); err != nil {
+		return noEOF(err)
+	}
+	encoding := bytes.NewReader(d.encoding.Bytes())
+
+	deltaIndicator, err := encoding.ReadByte()
+	if err != nil {
+		return noEOF(err)
+	}
+	if deltaIndicator != 0 {
+		return fmt.Errorf("%w: compressed sections", ErrUnsupportedVCDIFF)
+	}
+
+	var sectionLengths [3]uint64
+	for i := range sectionLengths {
+		if sectionLengths[i], err = readVarint(encoding); err != nil {
+			return err
+		}
+	}
+
+	var checksum []byte
+	if indicator&vcdiffWindowAdler32 != 0 {
+		checksum = make([]byte, 4)
+		if _, err = io.ReadFull(encoding, checksum); err != nil {
+			return err
+		}
+	}
+
+	// The data, instructions and addresses sections take the rest of the delta encoding.
+	var sections [3][]byte
+	rest := d.encoding.Bytes()[d.encoding.Len()-encoding.Len():]
+	for i := range sections {
+		if sectionLengths[i] > uint64(len(rest)) {
+			return ErrTruncatedDelta
+		}
+		sections[i], rest = rest[:sectionLengths[i]], rest[sectionLengths[i]:]
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%w: %d bytes after the sections of the window", ErrInvalidVCDIFF, len(rest))
+	}
+
+	targetCopies, err := d.decodeInstructions(sections, targetSize, sourceSize, sourcePosition)
+	if err != nil {
+		return err
+	}
+
+	// A window copying from its own target window is decoded in memory, the others are written as they are decoded.
+	out := newFile
+	hash := adler32.New()
+	if targetCopies {
+		d.target.Reset()
+		out = &d.target
+	} else if checksum != nil {
+		out = io.MultiWriter(newFile, hash)
+	}
+
+	if err = d.writeTarget(out, sections[0], sourceSize, sourcePosition); err != nil {
+		return err
+	}
+
+	if targetCopies {
+		hash.Write(d.target.Bytes())
+	}
+	if checksum != nil && hash.Sum32() != binary.BigEndian.Uint32(checksum) {
+		return ErrVCDIFFChecksum
+	}
+	if targetCopies {
+		if _, err = newFile.Write(d.target.Bytes()); err != nil {
+			return err
+		}
+	}
+	d.outputSize += targetSize
+
+	return nil
+}
+
+// decodeInstructions decodes the instructions of a window into d.operations and checks them against the window
+// sections and the limits of d.options. It reports whether a COPY reads the target window.
+func (d *vcdiffDecoder) decodeInstructions(sections [3][]byte, targetSize, sourceSize, sourcePosition uint64) (bool, error) {
+	dataSize := uint64(len(sections[0]))
+	instructions := bytes.NewReader(sections[1])
+	addresses := bytes.NewReader(sections[2])
+
+	d.operations = d.operations[:0]
+	d.cache = vcdiffAddressCache{}
+	var position, dataOffset uint64
+	targetCopies := false
+	for instructions.Len() > 0 {
+		code, _ := instructions.ReadByte()
+		for _, instruction := range vcdiffCodeTable[code] {
+			if instruction.inst == vcdiffNoop {
+				continue
+			}
+
+			size := uint64(instruction.size)
+			if size == 0 {
+				var err error
+				if size, err = readVarint(instructions); err != nil {
+					return false, err
+				}
+			}
+
+			if size > targetSize-position {
+				return false, fmt.Errorf("%w: instructions exceed target window size %d", ErrInvalidVCDIFF, targetSize)
+			}
+			if limit := d.options.MaxCommandLength; limit > 0 && size > uint64(limit) {
+				return false, &LimitError{Limit: "MaxCommandLength", Value: size, Max: limit}
+			}
+
+			operation := vcdiffOperation{inst: instruction.inst, size: size, offset: dataOffset}
+			switch instruction.inst {
+			case vcdiffAdd:
+				if size > dataSize-dataOffset {
+					return false, fmt.Errorf("%w: ADD past the end of the data section", ErrInvalidVCDIFF)
+				}
+				dataOffset += size
+			case vcdiffRun:
+				if dataOffset == dataSize {
+					return false, fmt.Errorf("%w: RUN past the end of the data section", ErrInvalidVCDIFF)
+				}
+				dataOffset++
+			case vcdiffCopy:
+				address, err := d.cache.decode(addresses, sourceSize+position, instruction.mode)
+				if err != nil {
+					return false, err
+				}
+				operation.offset = address
+
+				if address >= sourceSize {
+					targetCopies = true
+				} else if size > sourceSize-address {
+					return false, fmt.Errorf("%w: COPY spans the source segment and the target window", ErrInvalidVCDIFF)
+				} else if limit := d.options.MaxOriginalOffset; limit > 0 && sourcePosition+address+size > uint64(limit) {
+					return false, &LimitError{Limit: "MaxOriginalOffset", Value: sourcePosition + address + size, Max: limit}
+				}
+			}
+			d.operations = append(d.operations, operation)
+			position += size
+		}
+	}
+
+	if position != targetSize || dataOffset != dataSize || addresses.Len() > 0 {
+		return false, fmt.Errorf("%w: instructions do not match the window sections", ErrInvalidVCDIFF)
+	}
+
+	return targetCopies, nil
+}
+
+// writeTarget writes the output of d.operations to out. COPY instructions reading the target window need out to be
+// d.target.
+func (d *vcdiffDecoder) writeTarget(out io.Writer, data []byte, sourceSize, sourcePosition uint64) error {
+	for _, operation := range d.operations {
+		var err error
+		switch operation.inst {
+		case vcdiffAdd:
+			_, err = out.Write(data[operation.offset : operation.offset+operation.size])
+		case vcdiffRun:
+			err = d.writeRun(out, data[operation.offset], operation.size)
+		case vcdiffCopy:
+			if operation.offset < sourceSize {
+				err = d.copySource(out, sourcePosition+operation.offset, operation.size)
+			} else {
+				d.copyTarget(operation.offset-sourceSize, operation.size)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeRun writes size times b to out in chunks of vcdiffRunChunkSize bytes.
+func (d *vcdiffDecoder) writeRun(out io.Writer, b byte, size uint64) error {
+	if d.run == nil {
+		d.run = make([]byte, vcdiffRunChunkSize)
+	}
+
+	for size > 0 {
+		chunkSize := uint64(len(d.run))
+		if chunkSize > size {
+			chunkSize = size
+		}
+
+		chunk := d.run[:chunkSize]
+		for i := range chunk {
+			chunk[i] = b
+		}
+		if _, err := out.Write(chunk); err != nil {
+			return err
+		}
+		size -= chunkSize
+	}
+
+	return nil
+}
+
+// copySource writes size bytes of the original file at position to out.
+func (d *vcdiffDecoder) copySource(out io.Writer, position, size uint64) error {
+	if _, err := d.originalFile.Seek(int64(position), io.SeekStart); err != nil {
+		return err
+	}
+	if n, err := io.CopyN(out, d.originalFile, int64(size)); err != nil {
+		if err == io.EOF && uint64(n) < size {
+			return ErrCopyOutOfRange
+		}
+		return err
+	}
+
+	return nil
+}
+
+// copyTarget appends size bytes at begin of the target window decoded so far to it, where the copy may overlap its
+// own output.
+func (d *vcdiffDecoder) copyTarget(begin, size uint64) {
+	for size > 0 {
+		chunkSize := uint64(d.target.Len()) - begin
+		if chunkSize > size {
+			chunkSize = size
+		}
+
+		d.target.Write(d.target.Bytes()[begin : begin+chunkSize])
+		begin += chunkSize
+		size -= chunkSize
+	}
+}
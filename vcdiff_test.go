@@ -0,0 +1,292 @@
+package rdiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/adler32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteVCDIFF(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		_, blockSize, _, originalFile, err := generateFile(10, 100)
+		assert.Nil(t, err)
+		newFile := generateSparseChanges(originalFile, int(blockSize)*3)
+		newFile = append(newFile, make([]byte, 100)...)
+
+		delta, err := generateDelta(originalFile, newFile, uint32(blockSize), checksumType, 8, DefaultMaxLiteralSize)
+		assert.Nil(t, err)
+
+		vcdiff := &bytes.Buffer{}
+		err = WriteVCDIFF(bytes.NewReader(delta.Bytes()), vcdiff)
+		assert.Nil(t, err)
+		assert.Equal(t, vcdiffMagic, vcdiff.Bytes()[:4])
+
+		actualNewFile := &bytes.Buffer{}
+		err = PatchVCDIFF(bytes.NewReader(originalFile), actualNewFile, vcdiff)
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, actualNewFile.Bytes())
+	}
+}
+
+func TestWriteVCDIFF_Windows(t *testing.T) {
+	// Copies and literals larger than a target window are split between windows
+	originalFile, err := generateBytes(vcdiffMaxWindowSize + 1000)
+	assert.Nil(t, err)
+	literalData, err := generateBytes(vcdiffMaxWindowSize + 1000)
+	assert.Nil(t, err)
+
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(500, vcdiffMaxWindowSize))
+	assert.Nil(t, writer.WriteLiteral(literalData))
+	assert.Nil(t, writer.WriteCopy(0, 100))
+	assert.Nil(t, writer.Close())
+
+	vcdiff := &bytes.Buffer{}
+	err = WriteVCDIFF(delta, vcdiff)
+	assert.Nil(t, err)
+
+	expectedNewFile := append(append(append([]byte{}, originalFile[500:vcdiffMaxWindowSize+500]...), literalData...), originalFile[:100]...)
+	actualNewFile := &bytes.Buffer{}
+	err = PatchVCDIFF(bytes.NewReader(originalFile), actualNewFile, vcdiff)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedNewFile, actualNewFile.Bytes())
+}
+
+func TestWriteVCDIFF_Run(t *testing.T) {
+	// Repeated bytes are written as RUN instructions
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral(make([]byte, 100000)))
+	assert.Nil(t, writer.Close())
+
+	vcdiff := &bytes.Buffer{}
+	err = WriteVCDIFF(delta, vcdiff)
+	assert.Nil(t, err)
+
+	// Header, window indicator, delta encoding length, target size, delta indicator, section lengths, the data
+	// byte and the RUN instruction
+	expected := []byte{0xd6, 0xc3, 0xc4, 0x00, 0x00, 0x00, 0x0c, 0x86, 0x8d, 0x20, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x86, 0x8d, 0x20}
+	assert.Equal(t, expected, vcdiff.Bytes())
+
+	actualNewFile := &bytes.Buffer{}
+	err = PatchVCDIFF(bytes.NewReader(nil), actualNewFile, vcdiff)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 100000), actualNewFile.Bytes())
+}
+
+// testVCDIFF returns a VCDIFF delta with an application header and a single window that copies from a source
+// segment at offset 4 of "0123abcdefghijklmnop", and its target window. The window uses every address mode, RUN,
+// copies overlapping the target window and a double instruction code.
+func testVCDIFF(checksum bool) ([]byte, []byte) {
+	target := []byte("abcdwxyzefghefghefghefghzzzzabcdqijkl")
+
+	data := []byte("wxyzzq")
+	instructions := []byte{
+		20,   // COPY 4 VCD_SELF, "abcd"
+		5,    // ADD 4, "wxyz"
+		52,   // COPY 4 near 0, "efgh"
+		44,   // COPY 12 VCD_HERE, "efghefghefgh" from the target window
+		0, 4, // RUN 4, "zzzz"
+		116, // COPY 4 same 0, "abcd"
+		163, // ADD 1 and COPY 4 VCD_SELF, "q" and "ijkl"
+	}
+	addresses := []byte{0, 4, 4, 0, 8}
+
+	encoding := &bytes.Buffer{}
+	writeVarint(encoding, uint64(len(target)))
+	encoding.WriteByte(0)
+	writeVarint(encoding, uint64(len(data)))
+	writeVarint(encoding, uint64(len(instructions)))
+	writeVarint(encoding, uint64(len(addresses)))
+	indicator := byte(vcdiffWindowSource)
+	if checksum {
+		indicator |= vcdiffWindowAdler32
+		_ = binary.Write(encoding, binary.BigEndian, adler32.Checksum(target))
+	}
+	encoding.Write(data)
+	encoding.Write(instructions)
+	encoding.Write(addresses)
+
+	vcdiff := &bytes.Buffer{}
+	vcdiff.Write([]byte{0xd6, 0xc3, 0xc4, 0x00, vcdiffHeaderAppHeader, 3, 'a', 'p', 'p'})
+	vcdiff.WriteByte(indicator)
+	writeVarint(vcdiff, 16)
+	writeVarint(vcdiff, 4)
+	writeVarint(vcdiff, uint64(encoding.Len()))
+	vcdiff.Write(encoding.Bytes())
+
+	return vcdiff.Bytes(), target
+}
+
+func TestPatchVCDIFF(t *testing.T) {
+	originalFile := []byte("0123abcdefghijklmnop")
+
+	for _, checksum := range []bool{false, true} {
+		vcdiff, target := testVCDIFF(checksum)
+
+		// Two windows
+		actualNewFile := &bytes.Buffer{}
+		err := PatchVCDIFF(bytes.NewReader(originalFile), actualNewFile, bytes.NewReader(append(vcdiff, vcdiff[9:]...)))
+		assert.Nil(t, err)
+		assert.Equal(t, append(append([]byte{}, target...), target...), actualNewFile.Bytes())
+	}
+}
+
+func TestPatchVCDIFF_Errors(t *testing.T) {
+	originalFile := []byte("0123abcdefghijklmnop")
+	vcdiff, _ := testVCDIFF(true)
+
+	patch := func(vcdiff []byte) error {
+		return PatchVCDIFF(bytes.NewReader(originalFile), &bytes.Buffer{}, bytes.NewReader(vcdiff))
+	}
+
+	assert.True(t, errors.Is(patch([]byte{0xd6, 0xc3, 0xc5, 0x00, 0x00}), ErrBadMagic))
+	assert.True(t, errors.Is(patch([]byte{0xd6, 0xc3, 0xc4, 0x00, vcdiffHeaderDecompress, 0x01}), ErrUnsupportedVCDIFF))
+	assert.True(t, errors.Is(patch([]byte{0xd6, 0xc3, 0xc4, 0x00, vcdiffHeaderCodeTable}), ErrUnsupportedVCDIFF))
+	assert.True(t, errors.Is(patch(append(vcdiff[:9:9], vcdiffWindowTarget, 16, 0, 0)), ErrUnsupportedVCDIFF))
+
+	for _, length := range []int{3, 7, 12, len(vcdiff) - 1} {
+		assert.True(t, errors.Is(patch(vcdiff[:length]), ErrTruncatedDelta), length)
+	}
+
+	// The target window differs from its checksum
+	changed := append([]byte{}, vcdiff...)
+	changed[len(changed)-1]++
+	assert.True(t, errors.Is(patch(changed), ErrVCDIFFChecksum))
+
+	// The source segment goes past the end of the original file
+	err := PatchVCDIFF(bytes.NewReader(originalFile[:10]), &bytes.Buffer{}, bytes.NewReader(vcdiff))
+	assert.True(t, errors.Is(err, ErrCopyOutOfRange))
+}
+
+// testVCDIFFWindow returns a VCDIFF delta with a single window without source segment.
+func testVCDIFFWindow(targetSize uint64, data, instructions []byte) []byte {
+	encoding := &bytes.Buffer{}
+	writeVarint(encoding, targetSize)
+	encoding.WriteByte(0)
+	writeVarint(encoding, uint64(len(data)))
+	writeVarint(encoding, uint64(len(instructions)))
+	writeVarint(encoding, 0)
+	encoding.Write(data)
+	encoding.Write(instructions)
+
+	vcdiff := &bytes.Buffer{}
+	vcdiff.Write([]byte{0xd6, 0xc3, 0xc4, 0x00, 0x00, 0x00})
+	writeVarint(vcdiff, uint64(encoding.Len()))
+	vcdiff.Write(encoding.Bytes())
+
+	return vcdiff.Bytes()
+}
+
+func TestPatchVCDIFF_HostileWindow(t *testing.T) {
+	// 23 bytes asking for a 3 GiB RUN
+	instructions := &bytes.Buffer{}
+	instructions.WriteByte(vcdiffRunCode)
+	writeVarint(instructions, 3<<30)
+	vcdiff := testVCDIFFWindow(3<<30, []byte{'x'}, instructions.Bytes())
+	assert.Equal(t, 23, len(vcdiff))
+
+	start := time.Now()
+	newFile := &bytes.Buffer{}
+	err := PatchVCDIFF(bytes.NewReader(nil), newFile, bytes.NewReader(vcdiff))
+	assert.True(t, errors.Is(err, ErrUnsupportedVCDIFF))
+	assert.Equal(t, 0, newFile.Len())
+	assert.Less(t, time.Since(start), time.Second)
+
+	// A RUN larger than its target window is rejected before anything is written
+	instructions.Reset()
+	instructions.WriteByte(vcdiffRunCode)
+	writeVarint(instructions, 3<<30)
+	err = PatchVCDIFF(bytes.NewReader(nil), newFile, bytes.NewReader(testVCDIFFWindow(1000, []byte{'x'}, instructions.Bytes())))
+	assert.True(t, errors.Is(err, ErrInvalidVCDIFF))
+	assert.Equal(t, 0, newFile.Len())
+
+	// A delta encoding far longer than its target window needs is rejected before it is read
+	vcdiff = []byte{0xd6, 0xc3, 0xc4, 0x00, 0x00, 0x00}
+	vcdiff = append(vcdiff, 0x83, 0x80, 0x80, 0x80, 0x00) // 3 GiB delta encoding
+	vcdiff = append(vcdiff, 10, 0, 1, 1, 0, 'x', vcdiffRunCode)
+	vcdiff = append(vcdiff, bytes.Repeat([]byte{0}, 1<<20)...)
+	reader := &countingReader{in: bytes.NewReader(vcdiff)}
+	err = PatchVCDIFF(bytes.NewReader(nil), newFile, reader)
+	assert.True(t, errors.Is(err, ErrUnsupportedVCDIFF))
+	assert.Equal(t, 0, newFile.Len())
+	assert.Less(t, reader.count, int64(64<<10))
+
+	// A delta encoding shorter than its target window size
+	err = PatchVCDIFF(bytes.NewReader(nil), newFile, bytes.NewReader([]byte{0xd6, 0xc3, 0xc4, 0x00, 0x00, 0x00, 1, 0x81, 0x00}))
+	assert.True(t, errors.Is(err, ErrInvalidVCDIFF))
+}
+
+func TestPatchVCDIFFWithOptions_Limits(t *testing.T) {
+	originalFile := []byte("0123abcdefghijklmnop")
+	vcdiff, target := testVCDIFF(true)
+
+	for _, test := range []struct {
+		options *PatchOptions
+		limit   string
+	}{
+		{&PatchOptions{MaxOutputSize: int64(len(target)) - 1}, "MaxOutputSize"},
+		{&PatchOptions{MaxCommandLength: 11}, "MaxCommandLength"},
+		{&PatchOptions{MaxOriginalOffset: 15}, "MaxOriginalOffset"},
+	} {
+		newFile := &bytes.Buffer{}
+		err := PatchVCDIFFWithOptions(bytes.NewReader(originalFile), newFile, bytes.NewReader(vcdiff), test.options)
+		limitErr := &LimitError{}
+		if assert.True(t, errors.As(err, &limitErr), test.limit) {
+			assert.Equal(t, test.limit, limitErr.Limit)
+		}
+		assert.Equal(t, 0, newFile.Len())
+	}
+
+	// The limits hold the window
+	options := &PatchOptions{MaxOutputSize: int64(len(target)), MaxCommandLength: 12, MaxOriginalOffset: 16}
+	newFile := &bytes.Buffer{}
+	err := PatchVCDIFFWithOptions(bytes.NewReader(originalFile), newFile, bytes.NewReader(vcdiff), options)
+	assert.Nil(t, err)
+	assert.Equal(t, target, newFile.Bytes())
+}
+
+// maxWriteWriter records the size of the largest write.
+type maxWriteWriter struct {
+	size     int
+	maxWrite int
+}
+
+func (w *maxWriteWriter) Write(p []byte) (int, error) {
+	w.size += len(p)
+	if len(p) > w.maxWrite {
+		w.maxWrite = len(p)
+	}
+
+	return len(p), nil
+}
+
+func TestPatchVCDIFF_Streaming(t *testing.T) {
+	originalFile, err := generateBytes(vcdiffMaxWindowSize)
+	assert.Nil(t, err)
+
+	// A window of a RUN and a source segment COPY, both far larger than the writes
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteLiteral(make([]byte, 1<<20)))
+	assert.Nil(t, writer.WriteCopy(0, 1<<20))
+	assert.Nil(t, writer.Close())
+	vcdiff := &bytes.Buffer{}
+	assert.Nil(t, WriteVCDIFF(delta, vcdiff))
+
+	newFile := &maxWriteWriter{}
+	err = PatchVCDIFF(bytes.NewReader(originalFile), newFile, vcdiff)
+	assert.Nil(t, err)
+	assert.Equal(t, 2<<20, newFile.size)
+	assert.LessOrEqual(t, newFile.maxWrite, 32<<10)
+}
@@ -0,0 +1,74 @@
+package rdiff
+
+import (
+	"bytes"
+	"io"
+)
+
+// blockVerifier checks the blocks of the original file that copy commands read against the strong checksums of
+// the signature the delta was made from. Every block is checked once.
+type blockVerifier struct {
+	signature *Signature
+	checksum  *Checksum
+	verified  []bool
+}
+
+func newBlockVerifier(signature *Signature) (*blockVerifier, error) {
+	checksum, err := NewKeyedChecksum(signature.checksumType, signature.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &blockVerifier{signature: signature, checksum: checksum, verified: make([]bool, len(signature.strongChecksums))}, nil
+}
+
+// verify checks the blocks holding the range of a copy command. It returns ErrCopyOutOfRange when the range goes
+// past the blocks of the signature and a *StaleBlockError for the first block that does not match.
+func (v *blockVerifier) verify(source originalSource, position, length int64) error {
+	blockSize := int64(v.signature.blockSize)
+	if length > 0 && blockSize == 0 {
+		return ErrCopyOutOfRange
+	}
+
+	for block := position / blockSize; block*blockSize < position+length; block++ {
+		if block >= int64(len(v.verified)) {
+			return ErrCopyOutOfRange
+		}
+
+		if v.verified[block] {
+			continue
+		}
+
+		data, err := source.readBlock(block*blockSize, blockSize)
+		if err != nil {
+			return err
+		}
+
+		strongChecksum, err := v.checksum.strongChecksum(data, v.signature.strongChecksumSize)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(strongChecksum, v.signature.strongChecksums[block]) {
+			return &StaleBlockError{Block: block, Offset: block * blockSize}
+		}
+		v.verified[block] = true
+	}
+
+	return nil
+}
+
+// readBlock reads up to length bytes of originalFile at position, less only at the end of the file.
+func readBlock(originalFile io.ReadSeeker, position, length int64) ([]byte, error) {
+	if _, err := originalFile.Seek(position, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	n, err := io.ReadFull(originalFile, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return data[:n], nil
+}
@@ -0,0 +1,106 @@
+package rdiff
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestSignature(t *testing.T, originalFile []byte, checksumType ChecksumType, blockSize uint32) *Signature {
+	signatureBuffer := &bytes.Buffer{}
+	assert.Nil(t, WriteSignature(bytes.NewReader(originalFile), signatureBuffer, checksumType, blockSize, 8))
+	signature, err := ReadSignature(signatureBuffer)
+	assert.Nil(t, err)
+
+	return signature
+}
+
+func TestPatch_Signature(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		// Generate file
+		blockNumber, blockSize, _, originalFile, err := generateFile(10, 100)
+		assert.Nil(t, err)
+		newFile := append([]byte{1, 2, 3}, originalFile...)
+
+		signature := readTestSignature(t, originalFile, checksumType, uint32(blockSize))
+		delta := &bytes.Buffer{}
+		assert.Nil(t, WriteDelta(signature, bytes.NewReader(newFile), delta, uint32(blockSize*2)))
+
+		for _, options := range []*PatchOptions{{}, {ReadAhead: 16}} {
+			options.Signature = signature
+
+			// Unchanged original file
+			actualNewFile := &bytes.Buffer{}
+			err = PatchWithOptions(bytes.NewReader(originalFile), actualNewFile, bytes.NewReader(delta.Bytes()), options)
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, actualNewFile.Bytes())
+
+			actualNewFile.Reset()
+			err = PatchFromSource(NewReaderAtSource(bytes.NewReader(originalFile)), actualNewFile, bytes.NewReader(delta.Bytes()), options)
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, actualNewFile.Bytes())
+
+			// Changed original file, the copy of the changed block is not written
+			staleBlock := rand64(0, int(blockNumber-1))
+			staleFile := append([]byte{}, originalFile...)
+			staleFile[staleBlock*blockSize+blockSize/2]++
+
+			actualNewFile.Reset()
+			err = PatchWithOptions(bytes.NewReader(staleFile), actualNewFile, bytes.NewReader(delta.Bytes()), options)
+			var staleBlockError *StaleBlockError
+			assert.True(t, errors.As(err, &staleBlockError))
+			assert.True(t, errors.Is(err, ErrStaleOriginal))
+			assert.Equal(t, int64(staleBlock), staleBlockError.Block)
+			assert.Equal(t, int64(staleBlock*blockSize), staleBlockError.Offset)
+
+			var deltaError *DeltaError
+			assert.True(t, errors.As(err, &deltaError))
+			assert.Equal(t, newFile[:actualNewFile.Len()], actualNewFile.Bytes())
+
+			actualNewFile.Reset()
+			err = PatchFromSource(NewReaderAtSource(bytes.NewReader(staleFile)), actualNewFile, bytes.NewReader(delta.Bytes()), options)
+			assert.True(t, errors.As(err, &staleBlockError))
+			assert.Equal(t, int64(staleBlock), staleBlockError.Block)
+		}
+	}
+}
+
+func TestPatch_SignatureLastBlock(t *testing.T) {
+	originalFile, err := generateBytes(250)
+	assert.Nil(t, err)
+	signature := readTestSignature(t, originalFile, Rollsum_Blake2b, 100)
+
+	delta := &bytes.Buffer{}
+	writer, err := NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(220, 30))
+	assert.Nil(t, writer.WriteCopy(50, 100))
+	assert.Nil(t, writer.Close())
+
+	newFile := &bytes.Buffer{}
+	err = PatchWithOptions(bytes.NewReader(originalFile), newFile, bytes.NewReader(delta.Bytes()), &PatchOptions{Signature: signature})
+	assert.Nil(t, err)
+	assert.Equal(t, append(append([]byte{}, originalFile[220:]...), originalFile[50:150]...), newFile.Bytes())
+
+	// The last block is checked up to the end of the original file
+	err = PatchWithOptions(bytes.NewReader(append(originalFile, 0)), newFile, bytes.NewReader(delta.Bytes()), &PatchOptions{Signature: signature})
+	var staleBlockError *StaleBlockError
+	assert.True(t, errors.As(err, &staleBlockError))
+	assert.Equal(t, int64(2), staleBlockError.Block)
+
+	err = PatchWithOptions(bytes.NewReader(originalFile[:240]), newFile, bytes.NewReader(delta.Bytes()), &PatchOptions{Signature: signature})
+	assert.True(t, errors.As(err, &staleBlockError))
+	assert.Equal(t, int64(2), staleBlockError.Block)
+
+	// Copies past the blocks of the signature
+	delta.Reset()
+	writer, err = NewDeltaWriter(delta)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteCopy(300, 10))
+	assert.Nil(t, writer.Close())
+
+	err = PatchWithOptions(bytes.NewReader(append(originalFile, make([]byte, 150)...)), newFile, bytes.NewReader(delta.Bytes()), &PatchOptions{Signature: signature})
+	assert.True(t, errors.Is(err, ErrCopyOutOfRange))
+}